@@ -0,0 +1,136 @@
+// Copyright © 2018-2022 viafintech GmbH
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// acknowledgement silences the listings of an ip or network on a list until
+// it expires. Code restricts it to listings with the given answer address or
+// return code name.
+type acknowledgement struct {
+	IP      string `mapstructure:"ip"`
+	List    string `mapstructure:"list"`
+	Code    string `mapstructure:"code"`
+	Expires string `mapstructure:"expires"`
+	Comment string `mapstructure:"comment"`
+
+	network *net.IPNet
+	expires time.Time
+}
+
+// loadAcknowledgements reads the acknowledgements section of the
+// configuration file and of the acknowledgement file.
+func loadAcknowledgements() ([]*acknowledgement, error) {
+	var acks []*acknowledgement
+	if err := viper.UnmarshalKey("acknowledgements", &acks); err != nil {
+		return nil, fmt.Errorf("invalid acknowledgements configuration: %s", err.Error())
+	}
+
+	if AckFile != "" {
+		ackConfig := viper.New()
+		ackConfig.SetConfigFile(AckFile)
+		ackConfig.SetConfigType("yaml")
+		if err := ackConfig.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("failed to read the acknowledgement file: %s", err.Error())
+		}
+
+		var fileAcks []*acknowledgement
+		if err := ackConfig.UnmarshalKey("acknowledgements", &fileAcks); err != nil {
+			return nil, fmt.Errorf("invalid acknowledgement file %s: %s", AckFile, err.Error())
+		}
+		acks = append(acks, fileAcks...)
+	}
+
+	for _, ack := range acks {
+		if err := ack.parse(); err != nil {
+			return nil, err
+		}
+	}
+	return acks, nil
+}
+
+func (a *acknowledgement) parse() error {
+	if a.IP == "" || a.List == "" {
+		return fmt.Errorf("acknowledgement %q needs an ip and a list", a.Comment)
+	}
+
+	network, err := parseNetwork(a.IP)
+	if err != nil {
+		return fmt.Errorf("invalid ip of acknowledgement for %s: %s", a.List, err.Error())
+	}
+	a.network = network
+
+	// a date without time acknowledges until the end of the day
+	if expires, err := time.ParseInLocation("2006-01-02", a.Expires, time.Local); err == nil {
+		a.expires = expires.AddDate(0, 0, 1)
+	} else if a.expires, err = time.Parse(time.RFC3339, a.Expires); err != nil {
+		return fmt.Errorf(
+			"acknowledgement of %s on %s needs an expiry date like 2006-01-02 or 2006-01-02T15:04:05Z07:00, got %q",
+			a.IP,
+			a.List,
+			a.Expires,
+		)
+	}
+	return nil
+}
+
+// matches reports whether the acknowledgement applies to a listing at the
+// given time.
+func (a *acknowledgement) matches(result *dnsInfo, now time.Time) bool {
+	if !now.Before(a.expires) || !a.network.Contains(result.ip) ||
+		!strings.EqualFold(a.List, result.blacklist) {
+		return false
+	}
+	if a.Code == "" {
+		return true
+	}
+	for _, value := range append(result.answers, result.codes...) {
+		if strings.EqualFold(a.Code, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// acknowledgeListings marks the listings with a matching acknowledgement.
+// They are reported as acknowledged and do not count towards the state.
+func acknowledgeListings(results []*dnsInfo, acks []*acknowledgement, now time.Time) {
+	for _, result := range results {
+		if !result.listed || result.returnCode == OK {
+			continue
+		}
+		for _, ack := range acks {
+			if ack.matches(result, now) {
+				result.acknowledged = ack
+				result.returnCode = OK
+				result.weight = 0
+				break
+			}
+		}
+	}
+}
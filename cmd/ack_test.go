@@ -0,0 +1,176 @@
+// Copyright © 2018-2022 viafintech GmbH
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAcknowledgementExpiry(t *testing.T) {
+	listing := &dnsInfo{ip: net.ParseIP("192.0.2.10").To4(), blacklist: "zen.spamhaus.org", listed: true}
+
+	tests := []struct {
+		expires string
+		now     time.Time
+		matches bool
+	}{
+		// a bare date lasts until the end of that day
+		{"2026-10-18", time.Date(2026, 10, 18, 0, 0, 0, 0, time.Local), true},
+		{"2026-10-18", time.Date(2026, 10, 18, 23, 59, 59, 0, time.Local), true},
+		{"2026-10-18", time.Date(2026, 10, 19, 0, 0, 0, 0, time.Local), false},
+		{"2026-10-18T12:00:00Z", time.Date(2026, 10, 18, 11, 59, 59, 0, time.UTC), true},
+		{"2026-10-18T12:00:00Z", time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC), false},
+		{"2026-10-18T12:00:00+02:00", time.Date(2026, 10, 18, 9, 59, 59, 0, time.UTC), true},
+		{"2026-10-18T12:00:00+02:00", time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC), false},
+	}
+	for _, test := range tests {
+		ack := &acknowledgement{IP: "192.0.2.10", List: "zen.spamhaus.org", Expires: test.expires}
+		if err := ack.parse(); err != nil {
+			t.Fatalf("%s: %v", test.expires, err)
+		}
+		if matches := ack.matches(listing, test.now); matches != test.matches {
+			t.Errorf("%s at %s: got match %v, want %v", test.expires, test.now, matches, test.matches)
+		}
+	}
+}
+
+func TestAcknowledgementParseErrors(t *testing.T) {
+	tests := []*acknowledgement{
+		{List: "zen.spamhaus.org", Expires: "2026-10-18"},
+		{IP: "192.0.2.10", Expires: "2026-10-18"},
+		{IP: "192.0.2.300", List: "zen.spamhaus.org", Expires: "2026-10-18"},
+		{IP: "192.0.2.0/33", List: "zen.spamhaus.org", Expires: "2026-10-18"},
+		{IP: "192.0.2.10", List: "zen.spamhaus.org"},
+		{IP: "192.0.2.10", List: "zen.spamhaus.org", Expires: "18.10.2026"},
+		{IP: "192.0.2.10", List: "zen.spamhaus.org", Expires: "2026-10-18 12:00"},
+	}
+	for _, ack := range tests {
+		if err := ack.parse(); err == nil {
+			t.Errorf("%+v: got no error", ack)
+		}
+	}
+}
+
+func TestAcknowledgementMatches(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	listing := func(ip string, list string) *dnsInfo {
+		parsed := net.ParseIP(ip)
+		if ip4 := parsed.To4(); ip4 != nil {
+			parsed = ip4
+		}
+		return &dnsInfo{
+			ip:        parsed,
+			blacklist: list,
+			listed:    true,
+			answers:   []string{"127.0.0.2", "127.0.0.11"},
+			codes:     []string{"SBL", "PBL ISP"},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		ip      string
+		list    string
+		code    string
+		result  *dnsInfo
+		matches bool
+	}{
+		{"same ip", "192.0.2.10", "zen.spamhaus.org", "", listing("192.0.2.10", "zen.spamhaus.org"), true},
+		{"other ip", "192.0.2.10", "zen.spamhaus.org", "", listing("192.0.2.11", "zen.spamhaus.org"), false},
+		{"other list", "192.0.2.10", "zen.spamhaus.org", "", listing("192.0.2.10", "bl.spamcop.net"), false},
+		{"list in other case", "192.0.2.10", "ZEN.spamhaus.org", "", listing("192.0.2.10", "zen.spamhaus.org"), true},
+		{"network", "192.0.2.0/24", "zen.spamhaus.org", "", listing("192.0.2.200", "zen.spamhaus.org"), true},
+		{"outside of the network", "192.0.2.0/24", "zen.spamhaus.org", "", listing("192.0.3.1", "zen.spamhaus.org"), false},
+		{"ipv6 network", "2001:db8::/64", "zen.spamhaus.org", "", listing("2001:db8::25", "zen.spamhaus.org"), true},
+		{"ipv6 outside of the network", "2001:db8::/64", "zen.spamhaus.org", "", listing("2001:db8:1::25", "zen.spamhaus.org"), false},
+		{"code name", "192.0.2.10", "zen.spamhaus.org", "pbl isp", listing("192.0.2.10", "zen.spamhaus.org"), true},
+		{"answer address", "192.0.2.10", "zen.spamhaus.org", "127.0.0.11", listing("192.0.2.10", "zen.spamhaus.org"), true},
+		{"other code", "192.0.2.10", "zen.spamhaus.org", "XBL", listing("192.0.2.10", "zen.spamhaus.org"), false},
+		{"other answer address", "192.0.2.10", "zen.spamhaus.org", "127.0.0.4", listing("192.0.2.10", "zen.spamhaus.org"), false},
+	}
+	for _, test := range tests {
+		ack := &acknowledgement{IP: test.ip, List: test.list, Code: test.code, Expires: "2026-10-19"}
+		if err := ack.parse(); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if matches := ack.matches(test.result, now); matches != test.matches {
+			t.Errorf("%s: got match %v, want %v", test.name, matches, test.matches)
+		}
+	}
+}
+
+func TestAcknowledgeListings(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	ack := &acknowledgement{IP: "192.0.2.0/24", List: "zen.spamhaus.org", Expires: "2026-10-19"}
+	if err := ack.parse(); err != nil {
+		t.Fatal(err)
+	}
+
+	ip := net.ParseIP("192.0.2.10").To4()
+	listed := &dnsInfo{ip: ip, blacklist: "zen.spamhaus.org", listed: true, returnCode: CRITICAL, weight: 1}
+	otherList := &dnsInfo{ip: ip, blacklist: "bl.spamcop.net", listed: true, returnCode: CRITICAL, weight: 1}
+	unknown := &dnsInfo{ip: ip, blacklist: "zen.spamhaus.org", returnCode: UNKNOWN}
+	acknowledgeListings([]*dnsInfo{listed, otherList, unknown}, []*acknowledgement{ack}, now)
+
+	if listed.acknowledged != ack || listed.returnCode != OK || listed.weight != 0 {
+		t.Errorf("got listing acknowledged by %v with state %d and weight %v, want it acknowledged with state 0 and weight 0",
+			listed.acknowledged, listed.returnCode, listed.weight)
+	}
+	if otherList.acknowledged != nil || otherList.returnCode != CRITICAL {
+		t.Errorf("the listing on another list was acknowledged")
+	}
+	if unknown.acknowledged != nil || unknown.returnCode != UNKNOWN {
+		t.Errorf("the unknown answer was acknowledged")
+	}
+}
+
+func TestLoadAcknowledgements(t *testing.T) {
+	ackFile := filepath.Join(t.TempDir(), "acks.yaml")
+	err := os.WriteFile(ackFile, []byte(`
+acknowledgements:
+  - {ip: '192.0.2.0/24', list: 'bl.spamcop.net', expires: '2026-10-19', comment: 'from the file'}
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedAckFile := AckFile
+	AckFile = ackFile
+	defer func() { AckFile = savedAckFile }()
+
+	readTestConfig(t, `
+acknowledgements:
+  - {ip: '192.0.2.10', list: 'zen.spamhaus.org', code: 'PBL', expires: '2026-10-18T12:00:00Z', comment: 'from the config'}
+`)
+	acks, err := loadAcknowledgements()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(acks) != 2 || acks[0].Comment != "from the config" || acks[1].Comment != "from the file" {
+		t.Fatalf("got %+v, want the acknowledgement of the config and of the file", acks)
+	}
+	if acks[1].network.String() != "192.0.2.0/24" {
+		t.Errorf("got network %s, want 192.0.2.0/24", acks[1].network)
+	}
+}
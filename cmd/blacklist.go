@@ -0,0 +1,481 @@
+// Copyright © 2018-2022 viafintech GmbH
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+)
+
+// defaultReturnRange is the range of the answers a blacklist uses to signal a
+// listing unless the list configures a different one (RFC 5782 section 2.1).
+const defaultReturnRange = "127.0.0.0/8"
+
+// blacklistConfig holds the settings of a single blacklist. They are read
+// from the lists section of the configuration file, keyed by the list domain.
+// Weight is the score a listing adds, negative weights mark allowlists.
+// ListedState and UnreachableState override the state of listings without a
+// code specific state and of the list being unreachable.
+type blacklistConfig struct {
+	ReturnRange      []string          `mapstructure:"returnRange"`
+	ErrorCodes       map[string]string `mapstructure:"errorCodes"`
+	Codes            []*returnCode     `mapstructure:"codes"`
+	IPv6             bool              `mapstructure:"ipv6"`
+	Weight           *float64          `mapstructure:"weight"`
+	ListedState      string            `mapstructure:"listedState"`
+	UnreachableState string            `mapstructure:"unreachableState"`
+
+	returnNets []*net.IPNet
+}
+
+// returnCode names the meaning of an answer of a list, usually the sub-list
+// the ip was found on, and the state such a listing results in. Code is a
+// single address or a range like 127.0.0.4-127.0.0.7. For lists returning
+// a bitmask, Mask matches every answer with one of its bits set.
+type returnCode struct {
+	Code  string `mapstructure:"code"`
+	Mask  uint32 `mapstructure:"mask"`
+	Name  string `mapstructure:"name"`
+	State string `mapstructure:"state"`
+
+	first, last uint32
+	state       int
+}
+
+// blacklistConfigs holds the settings of the blacklists, the built-in ones
+// merged with the lists section of the configuration file.
+var blacklistConfigs = map[string]*blacklistConfig{
+	"zen.spamhaus.org": {
+		IPv6: true,
+		Codes: []*returnCode{
+			{Code: "127.0.0.2", Name: "SBL"},
+			{Code: "127.0.0.3", Name: "CSS"},
+			{Code: "127.0.0.4-127.0.0.7", Name: "XBL"},
+			{Code: "127.0.0.9", Name: "DROP"},
+			{Code: "127.0.0.10", Name: "PBL ISP"},
+			{Code: "127.0.0.11", Name: "PBL Spamhaus"},
+		},
+	},
+}
+
+// listGroup sets the states of all lists matching one of its patterns, e.g.
+// dul.* for the lists of dynamic ip ranges. The patterns use the syntax of
+// path.Match.
+type listGroup struct {
+	Name             string   `mapstructure:"name"`
+	Lists            []string `mapstructure:"lists"`
+	ListedState      string   `mapstructure:"listedState"`
+	UnreachableState string   `mapstructure:"unreachableState"`
+}
+
+// listGroups holds the list groups of the configuration file, the first
+// group containing a list wins.
+var listGroups []*listGroup
+
+// operatorErrorCodes maps the domain of a blacklist operator to the answers
+// its lists use to signal that a query was not answered, e.g. because it came
+// through a public resolver. They apply to all lists below the domain and can
+// be extended by the operatorErrorCodes section of the configuration file.
+var operatorErrorCodes = map[string]map[string]string{
+	"spamhaus.org": {
+		"127.255.255.252": "typo in the dnsbl name",
+		"127.255.255.254": "public resolver blocked",
+		"127.255.255.255": "excessive number of queries",
+	},
+	"uribl.com": {
+		"127.0.0.1": "query refused",
+	},
+	"surbl.org": {
+		"127.0.0.1": "query refused",
+	},
+}
+
+// loadBlacklistConfigs reads and validates the lists section of the
+// configuration file.
+func loadBlacklistConfigs() error {
+	errorCodes := map[string]map[string]string{}
+	if err := viper.UnmarshalKey("operatorErrorCodes", &errorCodes); err != nil {
+		return fmt.Errorf("invalid operatorErrorCodes configuration: %s", err.Error())
+	}
+	for operator, codes := range errorCodes {
+		if err := validateErrorCodes(codes); err != nil {
+			return fmt.Errorf("invalid error codes of operator %s: %s", operator, err.Error())
+		}
+		operator = strings.ToLower(operator)
+		if operatorErrorCodes[operator] == nil {
+			operatorErrorCodes[operator] = map[string]string{}
+		}
+		for code, reason := range codes {
+			operatorErrorCodes[operator][code] = reason
+		}
+	}
+
+	if err := viper.UnmarshalKey("listGroups", &listGroups); err != nil {
+		return fmt.Errorf("invalid listGroups configuration: %s", err.Error())
+	}
+	for _, group := range listGroups {
+		if err := group.validate(); err != nil {
+			return fmt.Errorf("invalid list group %s: %s", group.Name, err.Error())
+		}
+	}
+
+	for domain, rawConfig := range viper.GetStringMap("lists") {
+		domain = strings.ToLower(domain)
+
+		// settings missing in the configuration keep their built-in value,
+		// configured slices and maps replace the built-in ones instead of
+		// being merged into them
+		config := &blacklistConfig{}
+		if builtin, ok := blacklistConfigs[domain]; ok {
+			*config = *builtin
+			keys := configuredKeys(rawConfig)
+			if keys["codes"] {
+				config.Codes = nil
+			}
+			if keys["returnrange"] {
+				config.ReturnRange = nil
+			}
+			if keys["errorcodes"] {
+				config.ErrorCodes = nil
+			}
+		}
+
+		decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+			Result:           config,
+			WeaklyTypedInput: true,
+		})
+		if err != nil {
+			return err
+		}
+		if err := decoder.Decode(rawConfig); err != nil {
+			return fmt.Errorf("invalid configuration of list %s: %s", domain, err.Error())
+		}
+		if err := config.parse(); err != nil {
+			return fmt.Errorf("invalid configuration of list %s: %s", domain, err.Error())
+		}
+		blacklistConfigs[domain] = config
+	}
+	return nil
+}
+
+// configuredKeys returns the lower case keys of the raw configuration of a
+// list.
+func configuredKeys(rawConfig interface{}) map[string]bool {
+	keys := map[string]bool{}
+	switch raw := rawConfig.(type) {
+	case map[string]interface{}:
+		for key := range raw {
+			keys[strings.ToLower(key)] = true
+		}
+	case map[interface{}]interface{}:
+		for key := range raw {
+			keys[strings.ToLower(fmt.Sprint(key))] = true
+		}
+	}
+	return keys
+}
+
+// uniqueBlacklists returns the blacklist domains without the ones listed
+// more than once, every list is queried and counted only once.
+func uniqueBlacklists(domains []string) []string {
+	unique := make([]string, 0, len(domains))
+	seen := map[string]bool{}
+	for _, domain := range domains {
+		if !seen[strings.ToLower(domain)] {
+			seen[strings.ToLower(domain)] = true
+			unique = append(unique, domain)
+		}
+	}
+	return unique
+}
+
+// blacklistSettings returns the settings of the blacklist with the given
+// domain, falling back to the defaults for lists without configuration.
+func blacklistSettings(domain string) *blacklistConfig {
+	if config, ok := blacklistConfigs[strings.ToLower(domain)]; ok {
+		return config
+	}
+
+	config := &blacklistConfig{}
+	config.parse()
+	return config
+}
+
+// weight returns the score of a listing, 1 unless configured otherwise.
+func (c *blacklistConfig) weight() float64 {
+	if c.Weight == nil {
+		return 1
+	}
+	return *c.Weight
+}
+
+func (c *blacklistConfig) parse() error {
+	if err := validateErrorCodes(c.ErrorCodes); err != nil {
+		return err
+	}
+	if _, err := parseState(c.ListedState, OK); err != nil {
+		return err
+	}
+	if _, err := parseState(c.UnreachableState, OK); err != nil {
+		return err
+	}
+
+	ranges := c.ReturnRange
+	if len(ranges) == 0 {
+		ranges = []string{defaultReturnRange}
+	}
+
+	c.returnNets = nil
+	for _, returnRange := range ranges {
+		network, err := parseNetwork(returnRange)
+		if err != nil {
+			return err
+		}
+		c.returnNets = append(c.returnNets, network)
+	}
+
+	for _, code := range c.Codes {
+		if err := code.parse(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *returnCode) parse() error {
+	if c.Name == "" {
+		return errors.New("return code without name")
+	}
+
+	state, err := parseState(c.State, CRITICAL)
+	if err != nil {
+		return err
+	}
+	c.state = state
+
+	if c.Code == "" {
+		if c.Mask == 0 {
+			return fmt.Errorf("return code %s needs a code or a mask", c.Name)
+		}
+		return nil
+	}
+
+	bounds := strings.SplitN(c.Code, "-", 2)
+	if c.first, err = ipv4ToUint(bounds[0]); err != nil {
+		return err
+	}
+	c.last = c.first
+	if len(bounds) == 2 {
+		if c.last, err = ipv4ToUint(bounds[1]); err != nil {
+			return err
+		}
+	}
+	if c.first > c.last {
+		return fmt.Errorf("invalid return code range %q", c.Code)
+	}
+	return nil
+}
+
+// matches reports whether an answer of the list has the meaning of the code.
+func (c *returnCode) matches(answer net.IP) bool {
+	ip4 := answer.To4()
+	if ip4 == nil {
+		return false
+	}
+	value := binary.BigEndian.Uint32(ip4)
+
+	if c.Code != "" && (value < c.first || value > c.last) {
+		return false
+	}
+	return c.Mask == 0 || value&c.Mask != 0
+}
+
+// decodeAnswer returns the codes matching an answer of the list. An answer
+// without a configured code is returned as unnamed code without a state of
+// its own.
+func (c *blacklistConfig) decodeAnswer(answer net.IP) []*returnCode {
+	var matches []*returnCode
+	for _, code := range c.Codes {
+		if code.matches(answer) {
+			matches = append(matches, code)
+		}
+	}
+	if len(matches) == 0 {
+		matches = append(matches, &returnCode{Name: answer.String()})
+	}
+	return matches
+}
+
+func (g *listGroup) validate() error {
+	if len(g.Lists) == 0 {
+		return errors.New("no lists given")
+	}
+	for _, pattern := range g.Lists {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q", pattern)
+		}
+	}
+	if _, err := parseState(g.ListedState, OK); err != nil {
+		return err
+	}
+	_, err := parseState(g.UnreachableState, OK)
+	return err
+}
+
+func (g *listGroup) contains(domain string) bool {
+	for _, pattern := range g.Lists {
+		if matched, _ := path.Match(strings.ToLower(pattern), domain); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// configuredListState returns the state configured for listings on a list or
+// for the list being unreachable. The setting of the list itself takes
+// precedence over the one of the first group containing the list.
+func configuredListState(domain string, unreachable bool) (int, bool) {
+	domain = strings.ToLower(domain)
+	settings := blacklistSettings(domain)
+	name := settings.ListedState
+	if unreachable {
+		name = settings.UnreachableState
+	}
+
+	for _, group := range listGroups {
+		if name != "" {
+			break
+		}
+		if !group.contains(domain) {
+			continue
+		}
+		name = group.ListedState
+		if unreachable {
+			name = group.UnreachableState
+		}
+	}
+
+	if name == "" {
+		return 0, false
+	}
+	state, err := parseState(name, OK)
+	return state, err == nil
+}
+
+func ipv4ToUint(value string) (uint32, error) {
+	ip := net.ParseIP(strings.TrimSpace(value)).To4()
+	if ip == nil {
+		return 0, fmt.Errorf("invalid return code %q", value)
+	}
+	return binary.BigEndian.Uint32(ip), nil
+}
+
+// parseState converts a nagios state name like warning to its exit code. An
+// empty name results in the fallback state.
+func parseState(name string, fallback int) (int, error) {
+	switch strings.ToLower(name) {
+	case "":
+		return fallback, nil
+	case "ok":
+		return OK, nil
+	case "warning":
+		return WARNING, nil
+	case "critical":
+		return CRITICAL, nil
+	case "unknown":
+		return UNKNOWN, nil
+	}
+	return 0, fmt.Errorf("invalid state %q, expected ok, warning, critical or unknown", name)
+}
+
+// inReturnRange reports whether an answer of the list signals a listing.
+func (c *blacklistConfig) inReturnRange(ip net.IP) bool {
+	for _, network := range c.returnNets {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// errorReason returns the reason if the answer of the list is one of the error
+// codes of the list or its operator. The codes of the list take precedence.
+func (c *blacklistConfig) errorReason(domain string, answer net.IP) (string, bool) {
+	code := answer.String()
+	if reason, ok := c.ErrorCodes[code]; ok {
+		return reason, true
+	}
+
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	for operator, codes := range operatorErrorCodes {
+		if domain != operator && !strings.HasSuffix(domain, "."+operator) {
+			continue
+		}
+		if reason, ok := codes[code]; ok {
+			return reason, true
+		}
+	}
+	return "", false
+}
+
+func validateErrorCodes(codes map[string]string) error {
+	for code := range codes {
+		if net.ParseIP(code) == nil {
+			return fmt.Errorf("invalid error code %q", code)
+		}
+	}
+	return nil
+}
+
+func init() {
+	for domain, config := range blacklistConfigs {
+		if err := config.parse(); err != nil {
+			panic(fmt.Sprintf("invalid built-in configuration of list %s: %s", domain, err.Error()))
+		}
+	}
+}
+
+// parseNetwork parses a network in CIDR notation or a single address.
+func parseNetwork(value string) (*net.IPNet, error) {
+	if strings.Contains(value, "/") {
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", value)
+		}
+		return network, nil
+	}
+
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address %q", value)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
@@ -0,0 +1,126 @@
+// Copyright © 2018-2022 viafintech GmbH
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// readTestConfig loads a yaml configuration into viper and the lists section
+// into the blacklist settings, which are restored after the test.
+func readTestConfig(t *testing.T, config string) {
+	t.Helper()
+
+	saved := map[string]*blacklistConfig{}
+	for domain, settings := range blacklistConfigs {
+		copied := *settings
+		saved[domain] = &copied
+	}
+	t.Cleanup(func() {
+		blacklistConfigs = saved
+		viper.Reset()
+	})
+
+	viper.SetConfigType("yaml")
+	if err := viper.ReadConfig(strings.NewReader(config)); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadBlacklistConfigs(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadBlacklistConfigsReplacesBuiltinCodes(t *testing.T) {
+	// the example of the README
+	readTestConfig(t, `
+lists:
+  zen.spamhaus.org:
+    codes:
+      - {code: '127.0.0.2', name: 'SBL'}
+      - {code: '127.0.0.3', name: 'CSS'}
+      - {code: '127.0.0.4-127.0.0.7', name: 'XBL'}
+      - {code: '127.0.0.9', name: 'DROP'}
+      - {code: '127.0.0.10-127.0.0.11', name: 'PBL', state: 'ok'}
+  multi.example-dnsbl.org:
+    codes:
+      - {mask: 2, name: 'SPAM'}
+      - {mask: 4, name: 'PHISHING'}
+`)
+
+	tests := []struct {
+		domain string
+		answer string
+		names  []string
+		state  int
+	}{
+		{"zen.spamhaus.org", "127.0.0.11", []string{"PBL"}, OK},
+		{"zen.spamhaus.org", "127.0.0.10", []string{"PBL"}, OK},
+		{"zen.spamhaus.org", "127.0.0.5", []string{"XBL"}, CRITICAL},
+		{"multi.example-dnsbl.org", "127.0.0.6", []string{"SPAM", "PHISHING"}, CRITICAL},
+	}
+	for _, test := range tests {
+		codes := blacklistSettings(test.domain).decodeAnswer(net.ParseIP(test.answer))
+
+		var names []string
+		state := OK
+		for _, code := range codes {
+			names = append(names, code.Name)
+			state = worseState(state, code.state)
+		}
+		if strings.Join(names, ",") != strings.Join(test.names, ",") || state != test.state {
+			t.Errorf("%s %s: got codes %v with state %d, want %v with state %d",
+				test.domain, test.answer, names, state, test.names, test.state)
+		}
+	}
+
+	if len(blacklistConfigs["zen.spamhaus.org"].Codes) != 5 {
+		t.Errorf("got %d codes for zen.spamhaus.org, want the 5 configured ones",
+			len(blacklistConfigs["zen.spamhaus.org"].Codes))
+	}
+}
+
+func TestLoadBlacklistConfigsKeepsBuiltinCodes(t *testing.T) {
+	readTestConfig(t, `
+lists:
+  zen.spamhaus.org:
+    weight: 2
+`)
+
+	settings := blacklistSettings("zen.spamhaus.org")
+	if settings.weight() != 2 {
+		t.Errorf("got weight %v, want 2", settings.weight())
+	}
+	codes := settings.decodeAnswer(net.ParseIP("127.0.0.11"))
+	if len(codes) != 1 || codes[0].Name != "PBL Spamhaus" {
+		t.Errorf("got codes %v, want the built-in PBL Spamhaus", codes)
+	}
+}
+
+func TestUniqueBlacklists(t *testing.T) {
+	got := uniqueBlacklists([]string{"zen.spamhaus.org", "bl.spamcop.net", "ZEN.spamhaus.org", "zen.spamhaus.org"})
+	if want := "zen.spamhaus.org,bl.spamcop.net"; strings.Join(got, ",") != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+}
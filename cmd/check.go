@@ -21,12 +21,11 @@
 package cmd
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net"
-	"net/http"
 	"os"
 	"strings"
 	"time"
@@ -37,80 +36,219 @@ import (
 type dnsInfo struct {
 	returnCode int
 	Message    string
+	ip         net.IP
+	blacklist  string
+	listed     bool
+	reasons    []string
+
+	// latency is the time the queries of the list took
+	latency time.Duration
+
+	// weight is the score the result adds, it is only set for listings
+	// which are not ok and for allowlist hits
+	weight float64
+
+	// answers and codes are the addresses and the decoded return code names
+	// of a listing
+	answers []string
+	codes   []string
+
+	// unreachable is set if the list could not be queried at all
+	unreachable bool
+
+	// problem says why an unreachable or unknown list gave no answer about
+	// the listing, following the list name in the summary, e.g.
+	// "refused query: public resolver blocked"
+	problem string
+
+	// acknowledged is the acknowledgement silencing the listing
+	acknowledged *acknowledgement
+
+	// firstSeen is the time the listing was first seen according to the
+	// state file, newListing is set if that was less than the new listing
+	// age ago
+	firstSeen  time.Time
+	newListing bool
+
+	exchanges []*dnsExchange
 }
 
-type cloudflareDNSResponse struct {
-	Status int
+// dnsExchange is a query sent for a result together with its response, kept
+// for the verbose output.
+type dnsExchange struct {
+	name     string
+	qtype    uint16
+	response *dnsMessage
+	err      error
 }
 
+func (e *dnsExchange) String() string {
+	query := e.name + " " + dnsTypeString(e.qtype)
+	if e.err != nil {
+		return query + " failed: " + e.err.Error()
+	}
+
+	answers := "no answer records"
+	if len(e.response.Answers) > 0 {
+		answers = formatAnswers(e.response)
+	}
+	return fmt.Sprintf(
+		"%s via %s: %s, %s",
+		query,
+		e.response.Resolver,
+		dnsRcodeString(e.response.Rcode),
+		answers,
+	)
+}
+
+// maxConcurrentQueries limits the number of blacklist queries running at the
+// same time when many ips are checked.
+const maxConcurrentQueries = 128
+
 var checkCmd = &cobra.Command{
 	Use:   "check",
-	Short: "Expects an ip-address(ipv4) to check if it is blacklisted.[127.0.0.1]",
-	Long: `Checks the supplied ip-address(ipv4) and returns:
+	Short: "Expects ip-addresses(ipv4 or ipv6) or networks to check if they are blacklisted.[127.0.0.1 192.0.2.0/28]",
+	Long: `Checks the supplied ip-addresses(ipv4 or ipv6) and networks and returns the
+worst state of all addresses:
 * 0: not blacklisted
-* 1: a blacklist server timed out or timeout reached
-* 2: it was found on a blacklist server
-* 3: an unknown error occured`,
+* 1: the number of listings or of unreachable blacklist servers exceeds the warning threshold
+* 2: the number of listings exceeds the critical threshold
+* 3: an unknown error occured
+
+The thresholds use the nagios range syntax (10, 2:, ~:5, @1:3) and are
+compared against the number of lists reporting a listing and the number of
+lists which could not be queried. By default any listing is critical and any
+unreachable list is a warning.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		ip, error := isIPInputValid(args)
-		if error != OK {
-			log.Println("Unknown: Please specify a correct ip address.")
-			os.Exit(UNKNOWN)
-		}
-
-		dnsInfoCollector := make(chan *dnsInfo)
-		defer close(dnsInfoCollector)
-
-		reversedIPString := reverseIPString(ip)
-
-		isTimeOut := startTimer()
-		defer close(isTimeOut)
-
-		dnsBlacklistCheckerCount := len(BlacklistServers)
-
-		for _, blacklistServer := range BlacklistServers {
-			go checkIPAgainstBlacklistDomain(
-				dnsInfoCollector,
-				blacklistServer,
-				reversedIPString,
-			)
-		}
-
-		for {
-			select {
-			case dnsInfoOutput := <-dnsInfoCollector:
-				switch dnsInfoOutput.returnCode {
-				case WARNING:
-					log.Println("Warning: ", dnsInfoOutput.Message)
-					os.Exit(WARNING)
-				case UNKNOWN:
-					log.Println("Unknown: ", dnsInfoOutput.Message)
-					os.Exit(UNKNOWN)
-				case CRITICAL:
-					if SuppressCrit {
-						log.Println("Warning: ", dnsInfoOutput.Message)
-						os.Exit(WARNING)
-					} else {
-						log.Println("Critical: ", dnsInfoOutput.Message)
-						os.Exit(CRITICAL)
+		if err := validateOutput(); err != nil {
+			Output = outputNagios
+			exitWithStatus(UNKNOWN, err.Error())
+		}
+
+		thresholds, err := parseCheckThresholds()
+		if err != nil {
+			exitWithStatus(UNKNOWN, err.Error())
+		}
+
+		acks, err := loadAcknowledgements()
+		if err != nil {
+			exitWithStatus(UNKNOWN, err.Error())
+		}
+
+		var state *checkState
+		if StateFile != "" {
+			if state, err = loadCheckState(StateFile); err != nil {
+				exitWithStatus(UNKNOWN, err.Error())
+			}
+		}
+
+		ips, err := parseIPArguments(args, MaxAddresses)
+		if err != nil {
+			exitWithStatus(UNKNOWN, "Please specify correct ip addresses or networks: "+err.Error())
+		}
+
+		res, err := newResolver()
+		if err != nil {
+			exitWithStatus(UNKNOWN, "Failed to set up the resolver: "+err.Error())
+		}
+
+		queryCount := 0
+		for _, ip := range ips {
+			queryCount += len(blacklistsFor(ip))
+		}
+		if queryCount == 0 {
+			exitWithStatus(UNKNOWN, "None of the blacklists is configured as ipv6 capable.")
+		}
+
+		start := time.Now()
+		results := runChecks(res, ips)
+		acknowledgeListings(results, acks, time.Now())
+		if state != nil {
+			state.update(results, time.Now(), NewListingAge)
+			if err := state.save(StateFile); err != nil {
+				log.Println("Failed to write the state file:", err)
+			}
+		}
+		report := newCheckReport(ips, results, thresholds)
+		report.elapsed = time.Since(start)
+
+		switch Output {
+		case outputJSON:
+			printJSON(newJSONReport(report))
+		case outputCheckmk:
+			printCheckmk(report, CheckmkPerList)
+		case outputTextfile:
+			if err := writeTextfile(Textfile, report, time.Now()); err != nil {
+				exitWithStatus(UNKNOWN, "Failed to write the textfile: "+err.Error())
+			}
+		default:
+			printStatus(report.state, report.summary(), report.perfdata(), report.details(Verbosity))
+		}
+		os.Exit(report.state)
+	},
+}
+
+// runChecks queries all blacklists for all ips and waits for the results
+// until the timeout is reached. The lists which did not answer in time are
+// returned as unreachable, so the results are complete and in the order of
+// the ips and blacklists.
+func runChecks(res resolver, ips []net.IP) []*dnsInfo {
+	var results []*dnsInfo
+	resultIndex := map[string]int{}
+	for _, ip := range ips {
+		for _, blacklistServer := range blacklistsFor(ip) {
+			resultIndex[ip.String()+" "+blacklistServer] = len(results)
+			results = append(results, nil)
+		}
+	}
+
+	// buffered, so no query blocks once the results are not read anymore
+	dnsInfoCollector := make(chan *dnsInfo, len(results))
+
+	isTimeOut := startTimer()
+
+	go func() {
+		querySlots := make(chan bool, maxConcurrentQueries)
+		for _, ip := range ips {
+			for _, blacklistServer := range blacklistsFor(ip) {
+				querySlots <- true
+				go func(blacklistServer string, ip net.IP) {
+					defer func() { <-querySlots }()
+					checkIPAgainstBlacklistDomain(
+						dnsInfoCollector,
+						res,
+						blacklistServer,
+						ip,
+					)
+				}(blacklistServer, ip)
+			}
+		}
+	}()
+
+	for pending := len(results); pending > 0; pending-- {
+		select {
+		case dnsInfoOutput := <-dnsInfoCollector:
+			results[resultIndex[dnsInfoOutput.ip.String()+" "+dnsInfoOutput.blacklist]] = dnsInfoOutput
+		case <-isTimeOut:
+			for _, ip := range ips {
+				for _, blacklistServer := range blacklistsFor(ip) {
+					index := resultIndex[ip.String()+" "+blacklistServer]
+					if results[index] == nil {
+						results[index] = &dnsInfo{
+							returnCode:  WARNING,
+							Message:     fmt.Sprintf("Blacklistdomain %s did not answer within %d seconds", blacklistServer, Timeout),
+							problem:     fmt.Sprintf("did not answer within %d seconds", Timeout),
+							ip:          ip,
+							blacklist:   blacklistServer,
+							unreachable: true,
+						}
 					}
-				case OK:
-					dnsBlacklistCheckerCount--
-				}
-			case <-isTimeOut:
-				log.Printf(
-					"Warning: Timeout is reached but %d dns blacklist crawler were still working.\n",
-					dnsBlacklistCheckerCount,
-				)
-				os.Exit(WARNING)
-			default:
-				if dnsBlacklistCheckerCount <= 1 {
-					log.Println("Ok: The IP isn't blacklisted.")
-					os.Exit(OK)
 				}
 			}
+			return results
 		}
-	},
+	}
+	return results
 }
 
 func startTimer() chan bool {
@@ -122,121 +260,432 @@ func startTimer() chan bool {
 	return isTimerOver
 }
 
+// parseIPArguments parses the ip addresses and CIDR networks to check. The
+// networks are expanded to their addresses, at most maxAddresses addresses are
+// accepted in total.
+func parseIPArguments(args []string, maxAddresses int) ([]net.IP, error) {
+	if len(args) == 0 {
+		return nil, errors.New("no ip address given")
+	}
+
+	var ips []net.IP
+	seen := map[string]bool{}
+	for _, arg := range args {
+		addresses, err := expandIPArgument(arg, maxAddresses-len(ips))
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range addresses {
+			if !seen[ip.String()] {
+				seen[ip.String()] = true
+				ips = append(ips, ip)
+			}
+		}
+	}
+	return ips, nil
+}
+
+// expandIPArgument returns the address of a single ip or all addresses of a
+// CIDR network, failing if there are more than limit of them.
+func expandIPArgument(arg string, limit int) ([]net.IP, error) {
+	if !strings.Contains(arg, "/") {
+		ip, valid := isIPInputValid([]string{arg})
+		if valid != OK {
+			return nil, fmt.Errorf("%q is no correct ip address", arg)
+		}
+		if limit < 1 {
+			return nil, fmt.Errorf("more than %d addresses to check", MaxAddresses)
+		}
+		return []net.IP{ip}, nil
+	}
+
+	_, network, err := net.ParseCIDR(arg)
+	if err != nil {
+		return nil, fmt.Errorf("%q is no correct network", arg)
+	}
+	ones, bits := network.Mask.Size()
+	if bits-ones >= 31 || 1<<uint(bits-ones) > limit {
+		return nil, fmt.Errorf("the network %s has more than %d addresses to check", arg, MaxAddresses)
+	}
+
+	var ips []net.IP
+	for ip := network.IP; network.Contains(ip); ip = nextIP(ip) {
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+// nextIP returns the address following ip.
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
 func isIPInputValid(input []string) (net.IP, int) {
 	if len(input) <= 0 {
 		return nil, WARNING
 	}
-	parsedIP := net.ParseIP(input[0]).To4()
+	parsedIP := net.ParseIP(input[0])
 	if parsedIP == nil {
 		return nil, WARNING
 	}
+	if ip4 := parsedIP.To4(); ip4 != nil {
+		return ip4, OK
+	}
 	return parsedIP, OK
 }
 
+// reverseIPString builds the query label of an ip: the reversed octets of an
+// ipv4 address or the reversed nibbles of an ipv6 address (RFC 5782 section
+// 2.4).
 func reverseIPString(ip net.IP) string {
-	ipComponents := strings.Split(ip.String(), ".")
-	reversedIPAddress := fmt.Sprintf(
-		"%s.%s.%s.%s",
-		ipComponents[3],
-		ipComponents[2],
-		ipComponents[1],
-		ipComponents[0],
-	)
-	return reversedIPAddress
-}
-
-func checkIPAgainstBlacklistDomain(ret chan *dnsInfo, blacklistDomain string, reversedIPAddress string) {
-	client := &http.Client{}
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d", ip4[3], ip4[2], ip4[1], ip4[0])
+	}
 
-	url := fmt.Sprintf(
-		"https://cloudflare-dns.com/dns-query?name=%s.%s&type=A",
-		reversedIPAddress,
-		blacklistDomain,
-	)
+	ip16 := ip.To16()
+	nibbles := make([]string, 0, 2*net.IPv6len)
+	for i := net.IPv6len - 1; i >= 0; i-- {
+		nibbles = append(nibbles, fmt.Sprintf("%x.%x", ip16[i]&0x0f, ip16[i]>>4))
+	}
+	return strings.Join(nibbles, ".")
+}
 
-	dnsReq, err := http.NewRequest("GET", url, nil)
+// blacklistsFor returns the blacklists an ip can be checked against. ipv6
+// addresses are only checked against the lists configured as ipv6 capable.
+func blacklistsFor(ip net.IP) []string {
+	if ip.To4() != nil {
+		return BlacklistServers
+	}
 
-	if err != nil {
-		ret <- &dnsInfo{
-			WARNING,
-			fmt.Sprintf(
-				"Failed to create new dns request: %s",
-				err.Error(),
-			),
+	var blacklists []string
+	for _, blacklistServer := range BlacklistServers {
+		if blacklistSettings(blacklistServer).IPv6 {
+			blacklists = append(blacklists, blacklistServer)
 		}
 	}
+	return blacklists
+}
 
-	dnsReq.Header.Add("Accept", "application/dns-json")
-
-	dnsResp, err := client.Do(dnsReq)
+func checkIPAgainstBlacklistDomain(ret chan *dnsInfo, res resolver, blacklistDomain string, ip net.IP) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(Timeout)*time.Second)
+	defer cancel()
 
-	if err != nil {
-		ret <- &dnsInfo{
-			WARNING,
-			fmt.Sprintf(
-				"The dnssec request failed with: %s",
-				err.Error(),
-			),
+	start := time.Now()
+	var info *dnsInfo
+	var exchanges []*dnsExchange
+	attempts := 0
+	for {
+		attempts++
+		info = queryWithTimeout(ctx, res, blacklistDomain, ip)
+		exchanges = append(exchanges, info.exchanges...)
+		if !info.unreachable || attempts > Retries || !waitForRetry(ctx, attempts) {
+			break
 		}
 	}
+	if info.unreachable && attempts > 1 {
+		info.Message += fmt.Sprintf(" (%d attempts)", attempts)
+	}
 
-	defer dnsResp.Body.Close()
+	info.latency = time.Since(start)
+	info.exchanges = exchanges
+	info.ip = ip
+	info.blacklist = blacklistDomain
+	ret <- info
+}
 
-	respBody, err := ioutil.ReadAll(dnsResp.Body)
+// queryWithTimeout queries a list, giving up after the query timeout.
+func queryWithTimeout(ctx context.Context, res resolver, blacklistDomain string, ip net.IP) *dnsInfo {
+	if QueryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, QueryTimeout)
+		defer cancel()
+	}
+	return queryBlacklistDomain(ctx, res, blacklistDomain, ip)
+}
 
-	if err != nil {
-		ret <- &dnsInfo{
-			WARNING,
-			fmt.Sprintf(
-				"Parsing the dns body failed: %s",
-				err.Error(),
-			),
-		}
+// waitForRetry waits before the next attempt to query a list. The backoff is
+// doubled with every attempt. It returns false if the check ran out of time.
+func waitForRetry(ctx context.Context, attempts int) bool {
+	backoff := RetryBackoff << uint(attempts-1)
+	select {
+	case <-time.After(backoff):
+		return ctx.Err() == nil
+	case <-ctx.Done():
+		return false
 	}
+}
+
+func queryBlacklistDomain(ctx context.Context, res resolver, blacklistDomain string, ip net.IP) *dnsInfo {
+	queryName := reverseIPString(ip) + "." + blacklistDomain + "."
 
-	var dnsData cloudflareDNSResponse
-	err = json.Unmarshal(respBody, &dnsData)
+	dnsData, err := res.exchange(ctx, queryName, dnsTypeA)
+	info := evaluateResponse(dnsData, err, blacklistDomain, ip)
+	info.exchanges = append(info.exchanges, &dnsExchange{
+		name:     queryName,
+		qtype:    dnsTypeA,
+		response: dnsData,
+		err:      err,
+	})
+	if info.listed {
+		addListingReasons(ctx, info, res, queryName)
+	}
+	return info
+}
 
+func evaluateResponse(dnsData *dnsMessage, err error, blacklistDomain string, ip net.IP) *dnsInfo {
 	if err != nil {
-		ret <- &dnsInfo{
-			WARNING,
-			fmt.Sprintf(
-				"Unmarshalling the dns request failed: %s",
+		return &dnsInfo{
+			returnCode:  WARNING,
+			unreachable: true,
+			Message: fmt.Sprintf(
+				"The dns request for blacklistdomain %s failed with: %s",
+				blacklistDomain,
 				err.Error(),
 			),
+			problem: "failed: " + err.Error(),
 		}
 	}
 
-	switch dnsData.Status {
-	case 0:
-		ret <- &dnsInfo{
-			CRITICAL,
-			fmt.Sprintf(
-				"%s is listed on the blacklist with domain %s",
-				reversedIPAddress,
+	switch dnsData.Rcode {
+	case dnsRcodeSuccess:
+		return evaluateListing(dnsData, blacklistDomain, ip)
+	case dnsRcodeServerFailure:
+		// the resolver could not reach the list, this says nothing about a
+		// listing
+		return &dnsInfo{
+			returnCode:  WARNING,
+			unreachable: true,
+			Message: fmt.Sprintf(
+				"The dns request for blacklistdomain %s failed with SERVFAIL (answered by %s)",
 				blacklistDomain,
+				dnsData.Resolver,
 			),
+			problem: "answered SERVFAIL",
 		}
-	case 2, 3:
-		ret <- &dnsInfo{
-			OK,
-			fmt.Sprintf(
-				"%s is not listed on blacklistdomain:%s",
-				reversedIPAddress,
+	case dnsRcodeNameError:
+		return &dnsInfo{
+			returnCode: OK,
+			Message: fmt.Sprintf(
+				"%s is not listed on blacklistdomain:%s (answered by %s)",
+				ip,
 				blacklistDomain,
+				dnsData.Resolver,
 			),
 		}
 	default:
-		ret <- &dnsInfo{
-			UNKNOWN,
-			fmt.Sprintf(
-				"Check the official RCODE's of DNS Requests: %d",
-				dnsData.Status,
+		return &dnsInfo{
+			returnCode: UNKNOWN,
+			Message: fmt.Sprintf(
+				"Check the official RCODE's of DNS Requests: %d (answered by %s)",
+				dnsData.Rcode,
+				dnsData.Resolver,
+			),
+			problem: "answered " + dnsRcodeString(dnsData.Rcode),
+		}
+	}
+}
+
+// evaluateListing decides about a NOERROR answer. Error codes of the list
+// operator make the result unknown. Otherwise only A records within the
+// return range of the list count as a listing, an answer without any such
+// record is reported as an anomaly together with the records received.
+func evaluateListing(dnsData *dnsMessage, blacklistDomain string, ip net.IP) *dnsInfo {
+	settings := blacklistSettings(blacklistDomain)
+
+	var listedAddresses []string
+	for _, address := range answerData(dnsData, dnsTypeA) {
+		answer := net.ParseIP(address)
+		if answer == nil {
+			continue
+		}
+		if reason, isError := settings.errorReason(blacklistDomain, answer); isError {
+			return &dnsInfo{
+				returnCode: UNKNOWN,
+				Message: fmt.Sprintf(
+					"%s refused query: %s (%s, answered by %s)",
+					blacklistDomain,
+					reason,
+					address,
+					dnsData.Resolver,
+				),
+				problem: "refused query: " + reason,
+			}
+		}
+		if settings.inReturnRange(answer) {
+			listedAddresses = append(listedAddresses, address)
+		}
+	}
+
+	if len(listedAddresses) == 0 {
+		return &dnsInfo{
+			returnCode: UNKNOWN,
+			Message: fmt.Sprintf(
+				"Unexpected answer of blacklistdomain %s for %s: %s (answered by %s)",
+				blacklistDomain,
+				ip,
+				formatAnswers(dnsData),
+				dnsData.Resolver,
 			),
+			problem: "gave an unexpected answer: " + formatAnswers(dnsData),
+		}
+	}
+
+	// codes without a state of their own get the state of the list
+	listedState, ok := configuredListState(blacklistDomain, false)
+	if !ok {
+		listedState = CRITICAL
+	}
+
+	state := OK
+	var codeNames []string
+	for _, address := range listedAddresses {
+		for _, code := range settings.decodeAnswer(net.ParseIP(address)) {
+			if code.State == "" {
+				state = worseState(state, listedState)
+			} else {
+				state = worseState(state, code.state)
+			}
+			if !containsString(codeNames, code.Name) {
+				codeNames = append(codeNames, code.Name)
+			}
+		}
+	}
+
+	// a hit on an allowlist is good news, it only lowers the score
+	weight := settings.weight()
+	listType := "blacklist"
+	if weight < 0 {
+		state = OK
+		listType = "allowlist"
+	}
+	if state == OK && weight >= 0 {
+		weight = 0
+	}
+
+	return &dnsInfo{
+		returnCode: state,
+		listed:     true,
+		weight:     weight,
+		answers:    listedAddresses,
+		codes:      codeNames,
+		Message: fmt.Sprintf(
+			"%s is listed on the %s with domain %s as %s (%s, answered by %s)",
+			ip,
+			listType,
+			blacklistDomain,
+			strings.Join(codeNames, ", "),
+			strings.Join(listedAddresses, ", "),
+			dnsData.Resolver,
+		),
+	}
+}
+
+// addListingReasons queries the TXT records of a listing, which usually hold
+// the reason and the removal url, and adds them to the message. A failing
+// query leaves the listing untouched.
+func addListingReasons(ctx context.Context, info *dnsInfo, res resolver, queryName string) {
+	txtData, err := res.exchange(ctx, queryName, dnsTypeTXT)
+	info.exchanges = append(info.exchanges, &dnsExchange{
+		name:     queryName,
+		qtype:    dnsTypeTXT,
+		response: txtData,
+		err:      err,
+	})
+	if err != nil || txtData.Rcode != dnsRcodeSuccess {
+		return
+	}
+
+	info.reasons = answerData(txtData, dnsTypeTXT)
+	if len(info.reasons) > 0 {
+		info.Message += ": " + strings.Join(info.reasons, "; ")
+	}
+}
+
+// worseState returns the more severe of two nagios states, ordered by
+// ok < unknown < warning < critical.
+func worseState(a int, b int) int {
+	severity := map[int]int{OK: 0, UNKNOWN: 1, WARNING: 2, CRITICAL: 3}
+	if severity[b] > severity[a] {
+		return b
+	}
+	return a
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// formatAnswers returns the raw answer section of a message for messages.
+func formatAnswers(msg *dnsMessage) string {
+	if len(msg.Answers) == 0 {
+		return dnsRcodeString(msg.Rcode) + " without answer records"
+	}
+
+	records := make([]string, 0, len(msg.Answers))
+	for _, answer := range msg.Answers {
+		records = append(records, fmt.Sprintf(
+			"%s %d %s %s",
+			answer.Name,
+			answer.TTL,
+			dnsTypeString(answer.Type),
+			answer.Data,
+		))
+	}
+	return strings.Join(records, "; ")
+}
+
+// answerData returns the data of all answer records of the given type.
+func answerData(msg *dnsMessage, rrType uint16) []string {
+	var data []string
+	for _, answer := range msg.Answers {
+		if answer.Type == rrType {
+			data = append(data, answer.Data)
 		}
 	}
+	return data
 }
 
 func init() {
 	RootCmd.AddCommand(checkCmd)
+	checkCmd.Flags().StringVarP(&WarningThreshold, "warning", "w", "",
+		"Warning threshold for the number of lists reporting a listing, as nagios range")
+	checkCmd.Flags().StringVarP(&CriticalThreshold, "critical", "c", "",
+		"Critical threshold for the number of lists reporting a listing, as nagios range (default is 0 without any listing threshold)")
+	checkCmd.Flags().StringVar(&ScoreWarningThreshold, "score-warning", "",
+		"Warning threshold for the sum of the weights of the listings, as nagios range")
+	checkCmd.Flags().StringVar(&ScoreCriticalThreshold, "score-critical", "",
+		"Critical threshold for the sum of the weights of the listings, as nagios range")
+	checkCmd.Flags().StringVar(&UnreachableWarningThreshold, "unreachable-warning", "0",
+		"Warning threshold for the number of unreachable lists, as nagios range, e.g. 10% for more than a tenth of the lists")
+	checkCmd.Flags().StringVar(&UnreachableCriticalThreshold, "unreachable-critical", "",
+		"Critical threshold for the number of unreachable lists, as nagios range")
+	checkCmd.Flags().CountVarP(&Verbosity, "verbose", "v",
+		"Verbosity of the output, may be repeated up to -vvv: 1 names the listings, 2 adds all lists and 3 the dns queries")
+	checkCmd.Flags().StringVarP(&Output, "output", "o", outputNagios,
+		"Output format of the check: nagios, json, textfile (prometheus metrics written to --textfile) or checkmk (local check)")
+	checkCmd.Flags().BoolVar(&CheckmkPerList, "checkmk-per-list", false,
+		"Add a checkmk service for every list of every ip to the checkmk output")
+	checkCmd.Flags().StringVar(&Textfile, "textfile", "",
+		"File the textfile output is written to, e.g. /var/lib/node_exporter/textfile/dnsbl.prom")
+	checkCmd.Flags().StringVar(&AckFile, "ack-file", "",
+		"Yaml file with acknowledgements of listings in addition to the ones of the config file")
+	checkCmd.Flags().StringVar(&StateFile, "state-file", "",
+		"Json file keeping the listings between the runs of the check to tell new listings from old ones")
+	checkCmd.Flags().DurationVar(&NewListingAge, "new-listing-age", 24*time.Hour,
+		"Time a listing counts as new, only new listings can be critical if a state file is used")
+	checkCmd.Flags().BoolVar(&PerfdataLatency, "perfdata-latency", false,
+		"Add the query latency of every list to the performance data")
 }
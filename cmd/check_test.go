@@ -0,0 +1,141 @@
+// Copyright © 2018-2022 viafintech GmbH
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"net"
+	"testing"
+)
+
+func TestReverseIPString(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"127.0.0.2", "2.0.0.127"},
+		{"192.0.2.10", "10.2.0.192"},
+		{"::ffff:192.0.2.10", "10.2.0.192"},
+		{"2001:db8::1", "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2"},
+		{"2001:db8:1234:5678:9abc:def0:1:ff", "f.f.0.0.1.0.0.0.0.f.e.d.c.b.a.9.8.7.6.5.4.3.2.1.8.b.d.0.1.0.0.2"},
+	}
+	for _, test := range tests {
+		if got := reverseIPString(net.ParseIP(test.ip)); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.ip, got, test.want)
+		}
+	}
+}
+
+func TestIsIPInputValid(t *testing.T) {
+	tests := []struct {
+		input []string
+		ip    string
+		len   int
+		state int
+	}{
+		{[]string{"192.0.2.10"}, "192.0.2.10", net.IPv4len, OK},
+		{[]string{"::ffff:192.0.2.10"}, "192.0.2.10", net.IPv4len, OK},
+		{[]string{"2001:db8::1"}, "2001:db8::1", net.IPv6len, OK},
+		{[]string{"192.0.2.256"}, "", 0, WARNING},
+		{[]string{"example.org"}, "", 0, WARNING},
+		{nil, "", 0, WARNING},
+	}
+	for _, test := range tests {
+		ip, state := isIPInputValid(test.input)
+		if state != test.state || len(ip) != test.len || (ip != nil && ip.String() != test.ip) {
+			t.Errorf("%v: got %v (%d bytes) with state %d, want %q (%d bytes) with state %d",
+				test.input, ip, len(ip), state, test.ip, test.len, test.state)
+		}
+	}
+}
+
+func TestParseIPArguments(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  []string
+		max   int
+		count int
+		first string
+		last  string
+		err   bool
+	}{
+		{name: "single address", args: []string{"192.0.2.10"}, max: 256, count: 1, first: "192.0.2.10", last: "192.0.2.10"},
+		{name: "/32", args: []string{"192.0.2.10/32"}, max: 256, count: 1, first: "192.0.2.10", last: "192.0.2.10"},
+		{name: "/24 at the cap", args: []string{"192.0.2.0/24"}, max: 256, count: 256, first: "192.0.2.0", last: "192.0.2.255"},
+		{name: "/24 above the cap", args: []string{"192.0.2.0/24"}, max: 255, err: true},
+		{name: "network address not at the start", args: []string{"192.0.2.77/30"}, max: 256, count: 4, first: "192.0.2.76", last: "192.0.2.79"},
+		{name: "oversized prefix", args: []string{"10.0.0.0/8"}, max: 256, err: true},
+		{name: "whole address space", args: []string{"0.0.0.0/0"}, max: 256, err: true},
+		{name: "ipv6 /120", args: []string{"2001:db8::/120"}, max: 256, count: 256, first: "2001:db8::", last: "2001:db8::ff"},
+		{name: "ipv6 /64", args: []string{"2001:db8::/64"}, max: 256, err: true},
+		{name: "duplicates", args: []string{"192.0.2.10", "192.0.2.8/30", "::ffff:192.0.2.9"}, max: 256, count: 4, first: "192.0.2.10", last: "192.0.2.11"},
+		{name: "cap across arguments", args: []string{"192.0.2.0/31", "192.0.2.2"}, max: 2, err: true},
+		{name: "no arguments", max: 256, err: true},
+		{name: "invalid address", args: []string{"example.org"}, max: 256, err: true},
+		{name: "invalid prefix", args: []string{"192.0.2.0/33"}, max: 256, err: true},
+	}
+	for _, test := range tests {
+		ips, err := parseIPArguments(test.args, test.max)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: got %d addresses, want an error", test.name, len(ips))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if len(ips) != test.count || ips[0].String() != test.first || ips[len(ips)-1].String() != test.last {
+			t.Errorf("%s: got %d addresses from %s to %s, want %d from %s to %s", test.name,
+				len(ips), ips[0], ips[len(ips)-1], test.count, test.first, test.last)
+		}
+		seen := map[string]bool{}
+		for _, ip := range ips {
+			if seen[ip.String()] {
+				t.Errorf("%s: %s is checked twice", test.name, ip)
+			}
+			seen[ip.String()] = true
+		}
+	}
+}
+
+func TestNextIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"192.0.2.10", "192.0.2.11"},
+		{"192.0.2.255", "192.0.3.0"},
+		{"2001:db8::ffff", "2001:db8::1:0"},
+	}
+	for _, test := range tests {
+		ip := net.ParseIP(test.ip)
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		if got := nextIP(ip); got.String() != test.want {
+			t.Errorf("%s: got %s, want %s", test.ip, got, test.want)
+		}
+		if ip.String() != test.ip {
+			t.Errorf("%s: the address was changed to %s", test.ip, ip)
+		}
+	}
+}
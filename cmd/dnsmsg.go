@@ -0,0 +1,250 @@
+// Copyright © 2018-2022 viafintech GmbH
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	dnsTypeA     = 1
+	dnsTypeCNAME = 5
+	dnsTypeTXT   = 16
+	dnsTypeAAAA  = 28
+
+	dnsClassINET = 1
+
+	dnsRcodeSuccess       = 0
+	dnsRcodeServerFailure = 2
+	dnsRcodeNameError     = 3
+	dnsRcodeRefused       = 5
+
+	dnsHeaderLen = 12
+)
+
+var errDNSShortMessage = errors.New("dns message is too short")
+
+// dnsRecord is a single resource record of an answer section. Data holds the
+// presentation format of the record data, e.g. the address of an A record or
+// the concatenated strings of a TXT record.
+type dnsRecord struct {
+	Name string
+	Type uint16
+	TTL  uint32
+	Data string
+}
+
+// dnsMessage is the part of a dns response the blacklist check cares about.
+// Resolver names the upstream which answered the query.
+type dnsMessage struct {
+	ID        uint16
+	Rcode     int
+	Truncated bool
+	Answers   []dnsRecord
+	Resolver  string
+}
+
+func dnsTypeString(qtype uint16) string {
+	switch qtype {
+	case dnsTypeA:
+		return "A"
+	case dnsTypeCNAME:
+		return "CNAME"
+	case dnsTypeTXT:
+		return "TXT"
+	case dnsTypeAAAA:
+		return "AAAA"
+	}
+	return fmt.Sprintf("TYPE%d", qtype)
+}
+
+func dnsRcodeString(rcode int) string {
+	switch rcode {
+	case 0:
+		return "NOERROR"
+	case 1:
+		return "FORMERR"
+	case 2:
+		return "SERVFAIL"
+	case 3:
+		return "NXDOMAIN"
+	case 4:
+		return "NOTIMP"
+	case 5:
+		return "REFUSED"
+	}
+	return fmt.Sprintf("RCODE%d", rcode)
+}
+
+// packDNSQuery builds a recursive query for a single question in wire format.
+func packDNSQuery(id uint16, name string, qtype uint16) ([]byte, error) {
+	msg := make([]byte, dnsHeaderLen, dnsHeaderLen+len(name)+6)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], 1<<8) // RD
+	binary.BigEndian.PutUint16(msg[4:], 1)    // QDCOUNT
+
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, fmt.Errorf("invalid dns name %q", name)
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0)
+	if len(msg)-dnsHeaderLen > 255 {
+		return nil, fmt.Errorf("dns name %q is too long", name)
+	}
+
+	msg = append(msg, byte(qtype>>8), byte(qtype), 0, dnsClassINET)
+	return msg, nil
+}
+
+// parseDNSMessage decodes the header and the answer section of a dns
+// response in wire format.
+func parseDNSMessage(msg []byte) (*dnsMessage, error) {
+	if len(msg) < dnsHeaderLen {
+		return nil, errDNSShortMessage
+	}
+
+	flags := binary.BigEndian.Uint16(msg[2:])
+	if flags&(1<<15) == 0 {
+		return nil, errors.New("dns message is not a response")
+	}
+
+	result := &dnsMessage{
+		ID:        binary.BigEndian.Uint16(msg[0:]),
+		Rcode:     int(flags & 0xf),
+		Truncated: flags&(1<<9) != 0,
+	}
+
+	qdCount := int(binary.BigEndian.Uint16(msg[4:]))
+	anCount := int(binary.BigEndian.Uint16(msg[6:]))
+
+	offset := dnsHeaderLen
+	for i := 0; i < qdCount; i++ {
+		_, next, err := readDNSName(msg, offset)
+		if err != nil {
+			return nil, err
+		}
+		offset = next + 4
+	}
+
+	for i := 0; i < anCount; i++ {
+		name, next, err := readDNSName(msg, offset)
+		if err != nil {
+			return nil, err
+		}
+		if next+10 > len(msg) {
+			return nil, errDNSShortMessage
+		}
+		record := dnsRecord{
+			Name: name,
+			Type: binary.BigEndian.Uint16(msg[next:]),
+			TTL:  binary.BigEndian.Uint32(msg[next+4:]),
+		}
+		rdLength := int(binary.BigEndian.Uint16(msg[next+8:]))
+		rdStart := next + 10
+		if rdStart+rdLength > len(msg) {
+			return nil, errDNSShortMessage
+		}
+
+		record.Data, err = dnsRecordData(msg, record.Type, rdStart, rdLength)
+		if err != nil {
+			return nil, err
+		}
+		result.Answers = append(result.Answers, record)
+		offset = rdStart + rdLength
+	}
+
+	return result, nil
+}
+
+func dnsRecordData(msg []byte, rrType uint16, start int, length int) (string, error) {
+	rdata := msg[start : start+length]
+
+	switch rrType {
+	case dnsTypeA, dnsTypeAAAA:
+		if len(rdata) != net.IPv4len && len(rdata) != net.IPv6len {
+			return "", fmt.Errorf("invalid address record of length %d", len(rdata))
+		}
+		return net.IP(rdata).String(), nil
+	case dnsTypeCNAME:
+		name, _, err := readDNSName(msg, start)
+		return name, err
+	case dnsTypeTXT:
+		var text strings.Builder
+		for len(rdata) > 0 {
+			strLen := int(rdata[0])
+			if strLen+1 > len(rdata) {
+				return "", errDNSShortMessage
+			}
+			text.Write(rdata[1 : strLen+1])
+			rdata = rdata[strLen+1:]
+		}
+		return text.String(), nil
+	}
+
+	return fmt.Sprintf("%x", rdata), nil
+}
+
+// readDNSName reads a possibly compressed name starting at offset and returns
+// it together with the offset of the first byte following the name.
+func readDNSName(msg []byte, offset int) (string, int, error) {
+	var labels []string
+	next := -1
+
+	for jumps := 0; ; {
+		if offset >= len(msg) {
+			return "", 0, errDNSShortMessage
+		}
+		length := int(msg[offset])
+
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = offset + 1
+			}
+			return strings.Join(labels, ".") + ".", next, nil
+		case length&0xc0 == 0xc0:
+			if offset+1 >= len(msg) {
+				return "", 0, errDNSShortMessage
+			}
+			if next < 0 {
+				next = offset + 2
+			}
+			jumps++
+			if jumps > 32 {
+				return "", 0, errors.New("too many compression pointers in dns name")
+			}
+			offset = int(binary.BigEndian.Uint16(msg[offset:]) & 0x3fff)
+		default:
+			if offset+1+length > len(msg) {
+				return "", 0, errDNSShortMessage
+			}
+			labels = append(labels, string(msg[offset+1:offset+1+length]))
+			offset += 1 + length
+		}
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright © 2018-2022 viafintech GmbH
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	testDNSFlagsResponse = 0x8180 // QR, RD and RA
+	testDNSFlagsTC       = 0x0200
+	testDNSQuestion      = "2.0.0.127.zen.spamhaus.org."
+)
+
+// testDNSResponse turns a query for the test question into a response with
+// the flags and answer records.
+func testDNSResponse(t *testing.T, flags uint16, answers ...[]byte) []byte {
+	t.Helper()
+
+	msg, err := packDNSQuery(0x1234, testDNSQuestion, dnsTypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.BigEndian.PutUint16(msg[2:], flags)
+	binary.BigEndian.PutUint16(msg[6:], uint16(len(answers)))
+	for _, answer := range answers {
+		msg = append(msg, answer...)
+	}
+	return msg
+}
+
+// testDNSRecord packs an answer record with a compressed name pointing to
+// the question.
+func testDNSRecord(rrType uint16, ttl uint32, rdata []byte) []byte {
+	record := []byte{0xc0, dnsHeaderLen, byte(rrType >> 8), byte(rrType), 0, dnsClassINET, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(record[6:], ttl)
+	binary.BigEndian.PutUint16(record[10:], uint16(len(rdata)))
+	return append(record, rdata...)
+}
+
+func TestPackDNSQuery(t *testing.T) {
+	question := []byte("\x012\x010\x010\x03127\x03zen\x08spamhaus\x03org\x00\x00\x01\x00\x01")
+
+	tests := []struct {
+		name  string
+		qtype uint16
+		want  []byte
+		err   string
+	}{
+		{"2.0.0.127.zen.spamhaus.org", dnsTypeA, question, ""},
+		{"2.0.0.127.zen.spamhaus.org.", dnsTypeA, question, ""},
+		{"example.org", dnsTypeTXT, []byte("\x07example\x03org\x00\x00\x10\x00\x01"), ""},
+		{"", dnsTypeA, nil, `invalid dns name ""`},
+		{"2..127.zen.spamhaus.org", dnsTypeA, nil, `invalid dns name "2..127.zen.spamhaus.org"`},
+		{strings.Repeat("a", 64) + ".org", dnsTypeA, nil, "invalid dns name"},
+		{strings.Repeat(strings.Repeat("a", 63)+".", 4) + "org", dnsTypeA, nil, "is too long"},
+	}
+	for _, test := range tests {
+		msg, err := packDNSQuery(0x1234, test.name, test.qtype)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q: got error %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.name, err)
+			continue
+		}
+
+		header := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}
+		if !bytes.Equal(msg[:dnsHeaderLen], header) {
+			t.Errorf("%q: got header %x, want %x", test.name, msg[:dnsHeaderLen], header)
+		}
+		if !bytes.Equal(msg[dnsHeaderLen:], test.want) {
+			t.Errorf("%q: got question %x, want %x", test.name, msg[dnsHeaderLen:], test.want)
+		}
+	}
+}
+
+func TestParseDNSMessage(t *testing.T) {
+	// an answer record whose name points to itself
+	loop := testDNSResponse(t, testDNSFlagsResponse)
+	binary.BigEndian.PutUint16(loop[6:], 1)
+	loop = append(loop, 0xc0|byte(len(loop)>>8), byte(len(loop)))
+
+	tests := []struct {
+		name string
+		msg  []byte
+		want *dnsMessage
+		err  string
+	}{
+		{
+			name: "listing",
+			msg: testDNSResponse(t, testDNSFlagsResponse,
+				testDNSRecord(dnsTypeA, 300, []byte{127, 0, 0, 2}),
+				testDNSRecord(dnsTypeA, 300, []byte{127, 0, 0, 10})),
+			want: &dnsMessage{ID: 0x1234, Answers: []dnsRecord{
+				{Name: testDNSQuestion, Type: dnsTypeA, TTL: 300, Data: "127.0.0.2"},
+				{Name: testDNSQuestion, Type: dnsTypeA, TTL: 300, Data: "127.0.0.10"},
+			}},
+		},
+		{
+			name: "txt strings are concatenated",
+			msg: testDNSResponse(t, testDNSFlagsResponse,
+				testDNSRecord(dnsTypeTXT, 60, []byte("\x0bSee https:/\x0f/example.org/ip"))),
+			want: &dnsMessage{ID: 0x1234, Answers: []dnsRecord{
+				{Name: testDNSQuestion, Type: dnsTypeTXT, TTL: 60, Data: "See https://example.org/ip"},
+			}},
+		},
+		{
+			name: "compressed cname target",
+			msg: testDNSResponse(t, testDNSFlagsResponse,
+				testDNSRecord(dnsTypeCNAME, 60, []byte("\x04list\xc0\x16"))),
+			want: &dnsMessage{ID: 0x1234, Answers: []dnsRecord{
+				{Name: testDNSQuestion, Type: dnsTypeCNAME, TTL: 60, Data: "list.zen.spamhaus.org."},
+			}},
+		},
+		{
+			name: "aaaa",
+			msg: testDNSResponse(t, testDNSFlagsResponse,
+				testDNSRecord(dnsTypeAAAA, 60, []byte{0x20, 0x01, 0x0d, 0xb8, 15: 1})),
+			want: &dnsMessage{ID: 0x1234, Answers: []dnsRecord{
+				{Name: testDNSQuestion, Type: dnsTypeAAAA, TTL: 60, Data: "2001:db8::1"},
+			}},
+		},
+		{
+			name: "nxdomain",
+			msg:  testDNSResponse(t, testDNSFlagsResponse|dnsRcodeNameError),
+			want: &dnsMessage{ID: 0x1234, Rcode: dnsRcodeNameError},
+		},
+		{
+			name: "truncated",
+			msg:  testDNSResponse(t, testDNSFlagsResponse|testDNSFlagsTC),
+			want: &dnsMessage{ID: 0x1234, Truncated: true},
+		},
+		{
+			name: "query instead of response",
+			msg:  testDNSResponse(t, 0x0100),
+			err:  "dns message is not a response",
+		},
+		{
+			name: "short header",
+			msg:  []byte{0x12, 0x34, 0x81, 0x80, 0},
+			err:  errDNSShortMessage.Error(),
+		},
+		{
+			name: "answer cut off",
+			msg: testDNSResponse(t, testDNSFlagsResponse,
+				testDNSRecord(dnsTypeA, 300, []byte{127, 0, 0, 2}))[:dnsHeaderLen+32+12],
+			err: errDNSShortMessage.Error(),
+		},
+		{
+			name: "txt string longer than the record",
+			msg: testDNSResponse(t, testDNSFlagsResponse,
+				testDNSRecord(dnsTypeTXT, 60, []byte("\x0bshort"))),
+			err: errDNSShortMessage.Error(),
+		},
+		{
+			name: "address of wrong length",
+			msg: testDNSResponse(t, testDNSFlagsResponse,
+				testDNSRecord(dnsTypeA, 300, []byte{127, 0, 2})),
+			err: "invalid address record of length 3",
+		},
+		{
+			name: "compression pointer loop",
+			msg:  loop,
+			err:  "too many compression pointers in dns name",
+		},
+	}
+	for _, test := range tests {
+		msg, err := parseDNSMessage(test.msg)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: got error %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(msg, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, msg, test.want)
+		}
+	}
+}
+
+func TestReadDNSName(t *testing.T) {
+	tests := []struct {
+		name   string
+		msg    string
+		offset int
+		want   string
+		next   int
+		err    string
+	}{
+		{"plain", "\x03foo\x03bar\x00", 0, "foo.bar.", 9, ""},
+		{"root", "\x00", 0, ".", 1, ""},
+		{"pointer after labels", "\x03bar\x00\x03foo\xc0\x00\xff", 5, "foo.bar.", 11, ""},
+		{"pointer only", "\x03bar\x00\xc0\x00\xff", 5, "bar.", 7, ""},
+		{"chained pointers", "\x03baz\x00\x03bar\xc0\x00\x03foo\xc0\x05", 11, "foo.bar.baz.", 17, ""},
+		{"pointer to itself", "\xc0\x00", 0, "", 0, "too many compression pointers in dns name"},
+		{"pointers to each other", "\xc0\x02\xc0\x00", 0, "", 0, "too many compression pointers in dns name"},
+		{"label cut off", "\x05ab", 0, "", 0, errDNSShortMessage.Error()},
+		{"pointer cut off", "\x03foo\xc0", 0, "", 0, errDNSShortMessage.Error()},
+		{"pointer past the end", "\xc0\x10", 0, "", 0, errDNSShortMessage.Error()},
+		{"missing root label", "\x03foo", 0, "", 0, errDNSShortMessage.Error()},
+	}
+	for _, test := range tests {
+		name, next, err := readDNSName([]byte(test.msg), test.offset)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: got error %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if name != test.want || next != test.next {
+			t.Errorf("%s: got %q ending at %d, want %q ending at %d", test.name, name, next, test.want, test.next)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright © 2018-2022 viafintech GmbH
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	outputNagios   = "nagios"
+	outputJSON     = "json"
+	outputTextfile = "textfile"
+	outputCheckmk  = "checkmk"
+)
+
+// serviceName is the service the status line of the plugin output starts
+// with.
+const serviceName = "DNSBL"
+
+// pluginOutput is the stream the plugin output is written to. Nagios only
+// captures stdout, diagnostics are logged to stderr.
+var pluginOutput io.Writer = os.Stdout
+
+// printStatus writes the plugin output: the status line in the form
+// "DNSBL CRITICAL: text | perfdata" followed by the long output lines.
+func printStatus(state int, text string, perfdata string, longOutput []string) {
+	status := serviceName + " " + stateName(state) + ": " + text
+	if perfdata != "" {
+		status += " | " + perfdata
+	}
+	fmt.Fprintln(pluginOutput, status)
+	if len(longOutput) > 0 {
+		fmt.Fprintln(pluginOutput, strings.Join(longOutput, "\n"))
+	}
+}
+
+// exitWithStatus writes a status line without performance data and exits
+// with the state, e.g. if the check could not be run at all.
+func exitWithStatus(state int, text string) {
+	printFailure(state, text)
+	os.Exit(state)
+}
+
+// printFailure writes the state and text in the selected output format. For
+// checkmk it is a local check line of the overall service, which checkmk
+// shows instead of a garbled output.
+func printFailure(state int, text string) {
+	switch Output {
+	case outputJSON:
+		printJSON(&jsonReport{State: stateName(state), ExitCode: state, Summary: text})
+	case outputCheckmk:
+		fmt.Fprintf(pluginOutput, "%d \"%s\" - %s\n", state, serviceName, strings.Replace(text, "\n", " ", -1))
+	default:
+		printStatus(state, text, "", nil)
+	}
+}
+
+// validateOutput checks the output format selected with --output.
+func validateOutput() error {
+	switch Output {
+	case outputNagios, outputJSON, outputCheckmk:
+		return nil
+	case outputTextfile:
+		if Textfile == "" {
+			return errors.New("the textfile output needs the file to write with --textfile")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q, expected nagios, json, textfile or checkmk", Output)
+}
+
+// jsonReport is the document written by --output json.
+type jsonReport struct {
+	State    string        `json:"state"`
+	ExitCode int           `json:"exitCode"`
+	Summary  string        `json:"summary"`
+	IPs      []string      `json:"ips,omitempty"`
+	Elapsed  float64       `json:"elapsed"`
+	Results  []*jsonResult `json:"results,omitempty"`
+}
+
+// jsonResult is the result of a single list for a single ip. The query
+// fields describe the last query of the listing, Error is set if it failed.
+type jsonResult struct {
+	IP           string        `json:"ip"`
+	List         string        `json:"list"`
+	State        string        `json:"state"`
+	Listed       bool          `json:"listed"`
+	Unreachable  bool          `json:"unreachable"`
+	Acknowledged string        `json:"acknowledged,omitempty"`
+	New          bool          `json:"new,omitempty"`
+	FirstSeen    *time.Time    `json:"firstSeen,omitempty"`
+	Message      string        `json:"message"`
+	QueryName    string        `json:"queryName,omitempty"`
+	Resolver     string        `json:"resolver,omitempty"`
+	Rcode        string        `json:"rcode,omitempty"`
+	Error        string        `json:"error,omitempty"`
+	Answers      []*jsonAnswer `json:"answers,omitempty"`
+	Codes        []string      `json:"codes,omitempty"`
+	Reasons      []string      `json:"reasons,omitempty"`
+	Weight       float64       `json:"weight,omitempty"`
+	Latency      float64       `json:"latency"`
+}
+
+type jsonAnswer struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	TTL  uint32 `json:"ttl"`
+	Data string `json:"data"`
+}
+
+func newJSONReport(report *checkReport) *jsonReport {
+	doc := &jsonReport{
+		State:    stateName(report.state),
+		ExitCode: report.state,
+		Summary:  report.summary(),
+		Elapsed:  report.elapsed.Seconds(),
+	}
+	for _, ip := range report.ips {
+		doc.IPs = append(doc.IPs, ip.String())
+	}
+
+	for _, result := range report.results {
+		entry := &jsonResult{
+			IP:          result.ip.String(),
+			List:        result.blacklist,
+			State:       stateName(result.returnCode),
+			Listed:      result.listed,
+			Unreachable: result.unreachable,
+			New:         result.newListing,
+			Message:     result.Message,
+			Codes:       result.codes,
+			Reasons:     result.reasons,
+			Weight:      result.weight,
+			Latency:     result.latency.Seconds(),
+		}
+		if result.acknowledged != nil {
+			entry.Acknowledged = result.acknowledged.Comment
+		}
+		if !result.firstSeen.IsZero() {
+			firstSeen := result.firstSeen
+			entry.FirstSeen = &firstSeen
+		}
+
+		// the last address query, earlier ones were failed attempts
+		for _, exchange := range result.exchanges {
+			if exchange.qtype != dnsTypeA {
+				continue
+			}
+			entry.QueryName = exchange.name
+			entry.Error = ""
+			entry.Resolver, entry.Rcode, entry.Answers = "", "", nil
+			if exchange.err != nil {
+				entry.Error = exchange.err.Error()
+				continue
+			}
+			entry.Resolver = exchange.response.Resolver
+			entry.Rcode = dnsRcodeString(exchange.response.Rcode)
+			for _, answer := range exchange.response.Answers {
+				entry.Answers = append(entry.Answers, &jsonAnswer{
+					Name: answer.Name,
+					Type: dnsTypeString(answer.Type),
+					TTL:  answer.TTL,
+					Data: answer.Data,
+				})
+			}
+		}
+		doc.Results = append(doc.Results, entry)
+	}
+	return doc
+}
+
+// writeTextfile writes the results as metrics file for the textfile
+// collector of the prometheus node exporter. The file is replaced atomically,
+// so the collector never reads a partial file.
+func writeTextfile(path string, report *checkReport, now time.Time) error {
+	metrics := newExporter()
+	metrics.singleRun = true
+	metrics.update(report.results, now)
+
+	var data bytes.Buffer
+	metrics.writeMetrics(&data)
+	return writeFileAtomic(path, data.Bytes(), 0644)
+}
+
+// printCheckmk writes the results as checkmk local checks, a service per ip
+// and, if enabled, a service per ip and list, e.g.
+// 2 "DNSBL 192.0.2.10" listed=1;;1|unreachable=0;1;|latency=0.043 listed on 1/49 (...)
+func printCheckmk(report *checkReport, perList bool) {
+	t := report.thresholds
+	for _, ip := range report.ips {
+		ipReport := report.forIP(ip)
+
+		var latency time.Duration
+		for _, result := range ipReport.results {
+			if result.latency > latency {
+				latency = result.latency
+			}
+		}
+		metrics := fmt.Sprintf(
+			"listed=%d;%s;%s|unreachable=%d;%s;%s|latency=%.3f",
+			len(ipReport.listed),
+			t.listedWarning.level(),
+			t.listedCritical.level(),
+			len(ipReport.unreachable),
+			t.unreachableWarning.level(),
+			t.unreachableCritical.level(),
+			latency.Seconds(),
+		)
+		fmt.Fprintf(pluginOutput, "%d \"%s %s\" %s %s\n", ipReport.state, serviceName, ip, metrics, ipReport.summary())
+
+		if !perList {
+			continue
+		}
+		for _, result := range ipReport.results {
+			fmt.Fprintf(
+				pluginOutput,
+				"%d \"%s %s %s\" latency=%.3f %s\n",
+				result.returnCode,
+				serviceName,
+				ip,
+				result.blacklist,
+				result.latency.Seconds(),
+				strings.Replace(result.Message, "\n", " ", -1),
+			)
+		}
+	}
+}
+
+// printJSON writes the json document of a check.
+func printJSON(doc *jsonReport) {
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		// the document consists of plain values only
+		panic(err)
+	}
+	fmt.Fprintln(pluginOutput, string(data))
+}
@@ -0,0 +1,51 @@
+// Copyright © 2018-2022 viafintech GmbH
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPrintFailure(t *testing.T) {
+	savedOutput, savedWriter := Output, pluginOutput
+	t.Cleanup(func() {
+		Output, pluginOutput = savedOutput, savedWriter
+	})
+
+	tests := []struct {
+		output string
+		want   string
+	}{
+		{outputNagios, "DNSBL UNKNOWN: invalid threshold \"x\"\n"},
+		{outputTextfile, "DNSBL UNKNOWN: invalid threshold \"x\"\n"},
+		{outputCheckmk, "3 \"DNSBL\" - invalid threshold \"x\"\n"},
+		{outputJSON, "{\n  \"state\": \"UNKNOWN\",\n  \"exitCode\": 3,\n  \"summary\": \"invalid threshold \\\"x\\\"\",\n  \"elapsed\": 0\n}\n"},
+	}
+	for _, test := range tests {
+		var written bytes.Buffer
+		Output, pluginOutput = test.output, &written
+		printFailure(UNKNOWN, `invalid threshold "x"`)
+		if written.String() != test.want {
+			t.Errorf("%s: got %q, want %q", test.output, written.String(), test.want)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright © 2018-2022 viafintech GmbH
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// upstream is a resolver of the pool together with its health.
+type upstream struct {
+	resolver
+
+	mu             sync.Mutex
+	failures       int
+	sidelinedUntil time.Time
+}
+
+func (u *upstream) healthy(now time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return !now.Before(u.sidelinedUntil)
+}
+
+func (u *upstream) succeeded() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.failures = 0
+}
+
+func (u *upstream) failed(maxFailures int, cooldown time.Duration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.failures++
+	if u.failures >= maxFailures {
+		log.Printf("upstream %s failed %d times in a row, sidelined for %s", u.String(), u.failures, cooldown)
+		u.sidelinedUntil = time.Now().Add(cooldown)
+		u.failures = 0
+	}
+}
+
+// queueingResolver is implemented by resolvers which may have to wait locally
+// before a query is sent, like the dot resolver for a free connection. They
+// start the upstream timeout only once the query is sent.
+type queueingResolver interface {
+	exchangeWithin(ctx context.Context, name string, qtype uint16, timeout time.Duration) (*dnsMessage, error)
+}
+
+// resolverPool sends a query to the first healthy upstream and retries it on
+// the next one if the upstream failed, refused the query, answered SERVFAIL or
+// did not answer within the upstream timeout. Upstreams failing maxFailures
+// times in a row are sidelined for the cooldown period.
+type resolverPool struct {
+	upstreams   []*upstream
+	timeout     time.Duration
+	maxFailures int
+	cooldown    time.Duration
+}
+
+func newResolverPool(resolvers []resolver, timeout time.Duration, maxFailures int, cooldown time.Duration) *resolverPool {
+	pool := &resolverPool{
+		timeout:     timeout,
+		maxFailures: maxFailures,
+		cooldown:    cooldown,
+	}
+	for _, res := range resolvers {
+		pool.upstreams = append(pool.upstreams, &upstream{resolver: res})
+	}
+	return pool
+}
+
+func (p *resolverPool) String() string {
+	names := make([]string, 0, len(p.upstreams))
+	for _, u := range p.upstreams {
+		names = append(names, u.String())
+	}
+	return strings.Join(names, ",")
+}
+
+// candidates returns the healthy upstreams in their configured order. If all
+// of them are sidelined every upstream is tried anyway.
+func (p *resolverPool) candidates() []*upstream {
+	now := time.Now()
+	var healthy []*upstream
+	for _, u := range p.upstreams {
+		if u.healthy(now) {
+			healthy = append(healthy, u)
+		}
+	}
+	if len(healthy) == 0 {
+		return p.upstreams
+	}
+	return healthy
+}
+
+func (p *resolverPool) exchange(ctx context.Context, name string, qtype uint16) (*dnsMessage, error) {
+	err := errors.New("no upstream resolver configured")
+	for _, u := range p.candidates() {
+		var msg *dnsMessage
+		msg, err = p.exchangeWith(ctx, u, name, qtype)
+		if err == nil {
+			u.succeeded()
+			msg.Resolver = u.String()
+			return msg, nil
+		}
+		if ctx.Err() != nil {
+			// the query itself ran out of time, this is not the fault of
+			// the upstream
+			return nil, err
+		}
+		u.failed(p.maxFailures, p.cooldown)
+	}
+	return nil, err
+}
+
+func (p *resolverPool) exchangeWith(ctx context.Context, u *upstream, name string, qtype uint16) (*dnsMessage, error) {
+	msg, err := p.send(ctx, u, name, qtype)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", u.String(), err.Error())
+	}
+	switch msg.Rcode {
+	case dnsRcodeRefused:
+		return nil, fmt.Errorf("%s refused the query", u.String())
+	case dnsRcodeServerFailure:
+		return nil, fmt.Errorf("%s answered SERVFAIL", u.String())
+	}
+	return msg, nil
+}
+
+// send passes the query to the upstream, which has the upstream timeout to
+// answer it.
+func (p *resolverPool) send(ctx context.Context, u *upstream, name string, qtype uint16) (*dnsMessage, error) {
+	if queueing, ok := u.resolver.(queueingResolver); ok {
+		return queueing.exchangeWithin(ctx, name, qtype, p.timeout)
+	}
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+	return u.exchange(ctx, name, qtype)
+}
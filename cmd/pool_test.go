@@ -0,0 +1,184 @@
+// Copyright © 2018-2022 viafintech GmbH
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package cmd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeResolver answers every query with the same rcode or fails with err.
+type fakeResolver struct {
+	name  string
+	rcode int
+	err   error
+	calls int
+}
+
+func (r *fakeResolver) String() string {
+	return r.name
+}
+
+func (r *fakeResolver) exchange(ctx context.Context, name string, qtype uint16) (*dnsMessage, error) {
+	r.calls++
+	if r.err != nil {
+		return nil, r.err
+	}
+	return &dnsMessage{Rcode: r.rcode}, nil
+}
+
+func newTestPool(maxFailures int, resolvers ...*fakeResolver) *resolverPool {
+	list := make([]resolver, 0, len(resolvers))
+	for _, res := range resolvers {
+		list = append(list, res)
+	}
+	return newResolverPool(list, time.Second, maxFailures, time.Hour)
+}
+
+func discardLog(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+}
+
+func TestResolverPoolFailover(t *testing.T) {
+	discardLog(t)
+	tests := []struct {
+		name  string
+		first *fakeResolver
+		err   string
+	}{
+		{"error", &fakeResolver{name: "first", err: errors.New("connection refused")}, "first: connection refused"},
+		{"servfail", &fakeResolver{name: "first", rcode: dnsRcodeServerFailure}, "first answered SERVFAIL"},
+		{"refused", &fakeResolver{name: "first", rcode: dnsRcodeRefused}, "first refused the query"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			second := &fakeResolver{name: "second", rcode: dnsRcodeNameError}
+			pool := newTestPool(3, test.first, second)
+
+			msg, err := pool.exchange(context.Background(), "2.0.0.127.zen.spamhaus.org", dnsTypeA)
+			if err != nil {
+				t.Fatalf("exchange failed: %s", err)
+			}
+			if msg.Resolver != "second" || msg.Rcode != dnsRcodeNameError {
+				t.Errorf("got answer of %s with rcode %d, want second with NXDOMAIN", msg.Resolver, msg.Rcode)
+			}
+			if test.first.calls != 1 || second.calls != 1 {
+				t.Errorf("got %d and %d calls, want 1 each", test.first.calls, second.calls)
+			}
+
+			// with the second upstream failing as well the error of the
+			// last one is returned
+			test.first.calls = 0
+			second.err = errors.New("timeout")
+			if _, err := pool.exchange(context.Background(), "2.0.0.127.zen.spamhaus.org", dnsTypeA); err == nil || err.Error() != "second: timeout" {
+				t.Errorf("got error %v, want second: timeout", err)
+			}
+			if test.first.calls != 1 {
+				t.Errorf("first upstream got %d calls, want 1", test.first.calls)
+			}
+
+			// a pool of the failing upstream alone reports why it failed
+			alone := newTestPool(3, test.first)
+			if _, err := alone.exchange(context.Background(), "2.0.0.127.zen.spamhaus.org", dnsTypeA); err == nil || err.Error() != test.err {
+				t.Errorf("got error %v, want %s", err, test.err)
+			}
+		})
+	}
+}
+
+func TestResolverPoolSidelining(t *testing.T) {
+	discardLog(t)
+	first := &fakeResolver{name: "first", err: errors.New("connection refused")}
+	second := &fakeResolver{name: "second"}
+	pool := newTestPool(2, first, second)
+
+	for i := 0; i < 4; i++ {
+		if _, err := pool.exchange(context.Background(), "example.org", dnsTypeA); err != nil {
+			t.Fatalf("exchange %d failed: %s", i, err)
+		}
+	}
+	// sidelined after the second failure, the other queries skip it
+	if first.calls != 2 {
+		t.Errorf("sidelined upstream got %d calls, want 2", first.calls)
+	}
+	if second.calls != 4 {
+		t.Errorf("healthy upstream got %d calls, want 4", second.calls)
+	}
+	if candidates := pool.candidates(); len(candidates) != 1 || candidates[0].String() != "second" {
+		t.Errorf("got %d candidates, want only second", len(candidates))
+	}
+}
+
+func TestResolverPoolAllSidelined(t *testing.T) {
+	discardLog(t)
+	first := &fakeResolver{name: "first", err: errors.New("connection refused")}
+	second := &fakeResolver{name: "second", rcode: dnsRcodeServerFailure}
+	pool := newTestPool(1, first, second)
+
+	if _, err := pool.exchange(context.Background(), "example.org", dnsTypeA); err == nil {
+		t.Fatal("exchange succeeded with failing upstreams")
+	}
+	for _, u := range pool.upstreams {
+		if u.healthy(time.Now()) {
+			t.Fatalf("upstream %s is not sidelined", u.String())
+		}
+	}
+
+	// all upstreams are sidelined, so all of them are tried again
+	second.rcode = dnsRcodeSuccess
+	msg, err := pool.exchange(context.Background(), "example.org", dnsTypeA)
+	if err != nil {
+		t.Fatalf("exchange failed: %s", err)
+	}
+	if msg.Resolver != "second" {
+		t.Errorf("got answer of %s, want second", msg.Resolver)
+	}
+	if first.calls != 2 || second.calls != 2 {
+		t.Errorf("got %d and %d calls, want 2 each", first.calls, second.calls)
+	}
+}
+
+func TestResolverPoolCanceledQuery(t *testing.T) {
+	first := &fakeResolver{name: "first", err: context.Canceled}
+	second := &fakeResolver{name: "second"}
+	pool := newTestPool(1, first, second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := pool.exchange(ctx, "example.org", dnsTypeA)
+	if err == nil || !strings.HasPrefix(err.Error(), "first: ") {
+		t.Errorf("got error %v, want the one of first", err)
+	}
+	// the query ran out of time, the upstream is neither blamed nor
+	// skipped for the next one
+	if second.calls != 0 {
+		t.Errorf("second upstream got %d calls, want 0", second.calls)
+	}
+	if !pool.upstreams[0].healthy(time.Now()) {
+		t.Error("upstream was sidelined for a canceled query")
+	}
+}
@@ -0,0 +1,342 @@
+// Copyright © 2018-2022 viafintech GmbH
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSummaryListings is the number of listings named in the summary line.
+const maxSummaryListings = 5
+
+// checkReport aggregates the results of all lists of a check into the
+// overall state.
+type checkReport struct {
+	ips        []net.IP
+	results    []*dnsInfo
+	thresholds *checkThresholds
+	state      int
+	elapsed    time.Duration
+
+	listed       []*dnsInfo
+	unreachable  []*dnsInfo
+	unknown      []*dnsInfo
+	acknowledged []*dnsInfo
+
+	// score is the sum of the weights of the scored results
+	score  float64
+	scored []*dnsInfo
+}
+
+// newCheckReport decides the state of the results. The number of listings,
+// their score and the number of unreachable lists are compared against the
+// thresholds, the state of the listing count and the score is limited to the
+// worst state of the listings themselves, so lists or codes configured as
+// warning never make the check critical. With
+// several ips the thresholds apply to each ip and the worst state is
+// reported.
+func newCheckReport(ips []net.IP, results []*dnsInfo, thresholds *checkThresholds) *checkReport {
+	report := &checkReport{ips: ips, results: results, thresholds: thresholds, state: OK}
+
+	listingState := OK
+	unreachableCount := 0
+	for _, result := range results {
+		// lists with a configured unreachable state are not counted by the
+		// unreachable thresholds
+		overridden := false
+		if result.unreachable {
+			var state int
+			if state, overridden = configuredListState(result.blacklist, true); overridden {
+				result.returnCode = state
+			}
+		}
+		if SuppressCrit && result.returnCode == CRITICAL {
+			result.returnCode = WARNING
+		}
+
+		if result.weight != 0 {
+			report.score += result.weight
+			report.scored = append(report.scored, result)
+		}
+
+		switch {
+		case result.acknowledged != nil:
+			report.acknowledged = append(report.acknowledged, result)
+		case result.unreachable:
+			report.unreachable = append(report.unreachable, result)
+			if overridden {
+				report.state = worseState(report.state, result.returnCode)
+			} else {
+				unreachableCount++
+			}
+		case result.listed && result.returnCode != OK:
+			report.listed = append(report.listed, result)
+			listingState = worseState(listingState, result.returnCode)
+		case result.returnCode != OK:
+			report.unknown = append(report.unknown, result)
+			report.state = worseState(report.state, result.returnCode)
+		}
+	}
+
+	if len(ips) > 1 {
+		for _, ip := range ips {
+			report.state = worseState(report.state, report.forIP(ip).state)
+		}
+		return report
+	}
+
+	// allowlists only offset listings, a score below zero is as good as none
+	// and must not fall out of the range of the score thresholds
+	score := report.score
+	if score < 0 {
+		score = 0
+	}
+	countState := worseState(
+		stateFor(float64(len(report.listed)), float64(len(results)), thresholds.listedWarning, thresholds.listedCritical),
+		stateFor(score, 0, thresholds.scoreWarning, thresholds.scoreCritical),
+	)
+	if worseState(countState, listingState) == countState {
+		countState = listingState
+	}
+	report.state = worseState(report.state, countState)
+	report.state = worseState(report.state, stateFor(
+		float64(unreachableCount),
+		float64(len(results)),
+		thresholds.unreachableWarning,
+		thresholds.unreachableCritical,
+	))
+
+	// --suppresscrit overrides the thresholds and list states
+	if SuppressCrit && report.state == CRITICAL {
+		report.state = WARNING
+	}
+	return report
+}
+
+// forIP returns the report restricted to the results of a single ip.
+func (r *checkReport) forIP(ip net.IP) *checkReport {
+	var results []*dnsInfo
+	for _, result := range r.results {
+		if result.ip.Equal(ip) {
+			results = append(results, result)
+		}
+	}
+	return newCheckReport([]net.IP{ip}, results, r.thresholds)
+}
+
+// summary returns the text of the status line, e.g.
+// "listed on 3/49 (zen.spamhaus.org, ...); 1 unreachable (bl.spamcop.net
+// answered SERVFAIL)".
+func (r *checkReport) summary() string {
+	var text string
+	if len(r.listed) == 0 {
+		text = fmt.Sprintf("not listed (0/%d)", len(r.results))
+	} else {
+		names := make([]string, 0, maxSummaryListings+1)
+		for i, result := range r.listed {
+			if i == maxSummaryListings {
+				names = append(names, "...")
+				break
+			}
+			names = append(names, r.listingName(result))
+		}
+		text = fmt.Sprintf(
+			"listed on %d/%d (%s)",
+			len(r.listed),
+			len(r.results),
+			strings.Join(names, ", "),
+		)
+	}
+
+	if r.thresholds.scoring() && len(r.ips) == 1 {
+		text += "; score " + formatScore(r.score)
+	}
+	if len(r.unreachable) > 0 {
+		text += fmt.Sprintf("; %d unreachable (%s)", len(r.unreachable), r.problemNames(r.unreachable))
+	}
+	if len(r.unknown) > 0 {
+		text += fmt.Sprintf("; %d unknown (%s)", len(r.unknown), r.problemNames(r.unknown))
+	}
+	if len(r.acknowledged) > 0 {
+		text += fmt.Sprintf("; %d acknowledged", len(r.acknowledged))
+	}
+	return text
+}
+
+// perfdata returns the performance data of the check: the number of
+// listings, unreachable and checked lists, the score if there are score
+// thresholds, the duration of the check and, if enabled, the latency of each
+// list. The thresholds apply to each ip, so the counts are given per ip if
+// several ips were checked.
+func (r *checkReport) perfdata() string {
+	var values []string
+	if len(r.ips) > 1 {
+		for _, ip := range r.ips {
+			values = append(values, r.forIP(ip).countPerfdata(ip.String()+" ")...)
+		}
+	} else {
+		values = r.countPerfdata("")
+	}
+	values = append(values, fmt.Sprintf("'time'=%.3fs;;;0;", r.elapsed.Seconds()))
+
+	if PerfdataLatency {
+		for _, result := range r.results {
+			// lists which did not answer in time have no latency
+			if result.latency == 0 {
+				continue
+			}
+			label := result.blacklist
+			if len(r.ips) > 1 {
+				label = result.ip.String() + " " + label
+			}
+			values = append(values, fmt.Sprintf("'%s'=%.3fs;;;0;", label, result.latency.Seconds()))
+		}
+	}
+	return strings.Join(values, " ")
+}
+
+// countPerfdata returns the performance data of the counts and the score
+// with their thresholds, the labels start with prefix.
+func (r *checkReport) countPerfdata(prefix string) []string {
+	t := r.thresholds
+	total := len(r.results)
+	values := []string{
+		fmt.Sprintf("'%slisted'=%d;%s;%s;0;%d", prefix, len(r.listed), t.listedWarning, t.listedCritical, total),
+		fmt.Sprintf("'%sunreachable'=%d;%s;%s;0;%d", prefix, len(r.unreachable), t.unreachableWarning, t.unreachableCritical, total),
+		fmt.Sprintf("'%stotal'=%d;;;0;", prefix, total),
+	}
+	if t.scoring() {
+		values = append(values, fmt.Sprintf("'%sscore'=%s;%s;%s;;", prefix, formatScore(r.score), t.scoreWarning, t.scoreCritical))
+	}
+	return values
+}
+
+// details returns the long output lines for the verbosity level. Level 1
+// names every result which is not ok and every acknowledged listing, the
+// summary of every ip if several ips were checked and the score breakdown. Level 2 adds the results of all lists
+// with their latency and level 3 the dns queries and answers of each list.
+func (r *checkReport) details(verbosity int) []string {
+	if verbosity < 1 {
+		return nil
+	}
+
+	var lines []string
+	for _, ip := range r.ips {
+		ipReport := r
+		if len(r.ips) > 1 {
+			ipReport = r.forIP(ip)
+			lines = append(lines, ip.String()+" "+stateName(ipReport.state)+": "+ipReport.summary())
+		}
+		if r.thresholds.scoring() && len(ipReport.scored) > 0 {
+			lines = append(lines, ipReport.scoreBreakdown())
+		}
+
+		for _, result := range ipReport.results {
+			if result.returnCode == OK && result.acknowledged == nil && verbosity < 2 {
+				continue
+			}
+
+			line := stateName(result.returnCode) + ": " + result.Message
+			if ack := result.acknowledged; ack != nil {
+				line = fmt.Sprintf("ACKNOWLEDGED: %s (%s, until %s)", result.Message, ack.Comment, ack.Expires)
+			}
+			if !result.firstSeen.IsZero() {
+				since := formatSince(result.firstSeen, time.Now())
+				if result.newListing {
+					line = "NEW: " + line + ", since " + since
+				} else {
+					line += ", since " + since
+				}
+			}
+			if verbosity >= 2 && result.latency > 0 {
+				line += fmt.Sprintf(" (%.3fs)", result.latency.Seconds())
+			}
+			lines = append(lines, line)
+
+			if verbosity >= 3 {
+				for _, exchange := range result.exchanges {
+					lines = append(lines, "  "+exchange.String())
+				}
+			}
+		}
+	}
+	return lines
+}
+
+// scoreBreakdown returns the weights the score of a single ip is made of,
+// e.g. "Score 1.5: zen.spamhaus.org 2.5, list.dnswl.org -1".
+func (r *checkReport) scoreBreakdown() string {
+	parts := make([]string, 0, len(r.scored))
+	for _, result := range r.scored {
+		parts = append(parts, result.blacklist+" "+formatScore(result.weight))
+	}
+	return fmt.Sprintf("Score %s: %s", formatScore(r.score), strings.Join(parts, ", "))
+}
+
+func (r *checkReport) listingName(result *dnsInfo) string {
+	name := result.blacklist
+	if len(r.ips) > 1 {
+		name = result.ip.String() + " on " + result.blacklist
+	}
+	if result.newListing {
+		name = "NEW: " + name + " since " + formatSince(result.firstSeen, time.Now())
+	}
+	return name
+}
+
+// problemNames names the lists of unreachable or unknown results for the
+// summary, the first one together with its problem.
+func (r *checkReport) problemNames(results []*dnsInfo) string {
+	names := make([]string, 0, maxSummaryListings+1)
+	for i, result := range results {
+		if i == maxSummaryListings {
+			names = append(names, "...")
+			break
+		}
+		name := r.listingName(result)
+		if i == 0 && result.problem != "" {
+			name += " " + result.problem
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, ", ")
+}
+
+func formatScore(score float64) string {
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
+
+// stateName returns the name of a nagios state as used in the output.
+func stateName(state int) string {
+	switch state {
+	case OK:
+		return "OK"
+	case WARNING:
+		return "WARNING"
+	case CRITICAL:
+		return "CRITICAL"
+	}
+	return "UNKNOWN"
+}
@@ -0,0 +1,240 @@
+// Copyright © 2018-2022 viafintech GmbH
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"net"
+	"testing"
+)
+
+// testThresholds builds check thresholds from nagios ranges in the order
+// listed warning, listed critical, score warning, score critical,
+// unreachable warning and unreachable critical.
+func testThresholds(t *testing.T, specs ...string) *checkThresholds {
+	t.Helper()
+
+	parsed := make([]*threshold, 6)
+	for i, spec := range specs {
+		var err error
+		if parsed[i], err = parseThreshold(spec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &checkThresholds{
+		listedWarning:       parsed[0],
+		listedCritical:      parsed[1],
+		scoreWarning:        parsed[2],
+		scoreCritical:       parsed[3],
+		unreachableWarning:  parsed[4],
+		unreachableCritical: parsed[5],
+	}
+}
+
+func TestCheckReportNegativeScore(t *testing.T) {
+	ip := net.ParseIP("192.0.2.10")
+	thresholds := testThresholds(t, "", "", "1", "3", "0", "")
+
+	tests := []struct {
+		name    string
+		results []*dnsInfo
+		score   float64
+		state   int
+	}{
+		{
+			name: "allowlist hit only",
+			results: []*dnsInfo{
+				{ip: ip, blacklist: "list.dnswl.org", returnCode: OK, listed: true, weight: -3},
+			},
+			score: -3,
+			state: OK,
+		},
+		{
+			name: "listing offset by an allowlist hit",
+			results: []*dnsInfo{
+				{ip: ip, blacklist: "zen.spamhaus.org", returnCode: CRITICAL, listed: true, weight: 2},
+				{ip: ip, blacklist: "list.dnswl.org", returnCode: OK, listed: true, weight: -3},
+			},
+			score: -1,
+			state: OK,
+		},
+		{
+			name: "listings outweighing an allowlist hit",
+			results: []*dnsInfo{
+				{ip: ip, blacklist: "zen.spamhaus.org", returnCode: CRITICAL, listed: true, weight: 4},
+				{ip: ip, blacklist: "bl.spamcop.net", returnCode: CRITICAL, listed: true, weight: 1},
+				{ip: ip, blacklist: "list.dnswl.org", returnCode: OK, listed: true, weight: -1},
+			},
+			score: 4,
+			state: CRITICAL,
+		},
+	}
+	for _, test := range tests {
+		report := newCheckReport([]net.IP{ip}, test.results, thresholds)
+		if report.score != test.score || report.state != test.state {
+			t.Errorf("%s: got score %v with state %d, want score %v with state %d",
+				test.name, report.score, report.state, test.score, test.state)
+		}
+	}
+}
+
+func TestNewCheckReport(t *testing.T) {
+	ip := net.ParseIP("192.0.2.10")
+	otherIP := net.ParseIP("192.0.2.11")
+	listing := func(ip net.IP, list string, state int) *dnsInfo {
+		return &dnsInfo{ip: ip, blacklist: list, returnCode: state, listed: true, weight: 1}
+	}
+	clean := func(ip net.IP, list string) *dnsInfo {
+		return &dnsInfo{ip: ip, blacklist: list, returnCode: OK}
+	}
+	unreachable := func(ip net.IP, list string) *dnsInfo {
+		return &dnsInfo{ip: ip, blacklist: list, returnCode: WARNING, unreachable: true}
+	}
+	unknown := func(ip net.IP, list string) *dnsInfo {
+		return &dnsInfo{ip: ip, blacklist: list, returnCode: UNKNOWN}
+	}
+
+	tests := []struct {
+		name     string
+		specs    []string // nil for the default thresholds
+		config   string
+		suppress bool
+		ips      []net.IP
+		results  []*dnsInfo
+		state    int
+	}{
+		{
+			name:    "default, clean",
+			results: []*dnsInfo{clean(ip, "zen.spamhaus.org"), clean(ip, "bl.spamcop.net")},
+			state:   OK,
+		},
+		{
+			name:    "default, single listing",
+			results: []*dnsInfo{listing(ip, "zen.spamhaus.org", CRITICAL), clean(ip, "bl.spamcop.net")},
+			state:   CRITICAL,
+		},
+		{
+			name:    "default, single unreachable list",
+			results: []*dnsInfo{clean(ip, "zen.spamhaus.org"), unreachable(ip, "bl.spamcop.net")},
+			state:   WARNING,
+		},
+		{
+			name:    "default, unknown answer",
+			results: []*dnsInfo{clean(ip, "zen.spamhaus.org"), unknown(ip, "bl.spamcop.net")},
+			state:   UNKNOWN,
+		},
+		{
+			name:    "listing count below critical",
+			specs:   []string{"0", "1", "", "", "0", ""},
+			results: []*dnsInfo{listing(ip, "zen.spamhaus.org", CRITICAL), clean(ip, "bl.spamcop.net")},
+			state:   WARNING,
+		},
+		{
+			name:    "listing count above critical",
+			specs:   []string{"0", "1", "", "", "0", ""},
+			results: []*dnsInfo{listing(ip, "zen.spamhaus.org", CRITICAL), listing(ip, "bl.spamcop.net", CRITICAL)},
+			state:   CRITICAL,
+		},
+		{
+			name:    "listing count capped by the state of the listings",
+			specs:   []string{"0", "1", "", "", "0", ""},
+			results: []*dnsInfo{listing(ip, "zen.spamhaus.org", WARNING), listing(ip, "bl.spamcop.net", WARNING)},
+			state:   WARNING,
+		},
+		{
+			name:    "listing count within the thresholds",
+			specs:   []string{"2", "3", "", "", "0", ""},
+			results: []*dnsInfo{listing(ip, "zen.spamhaus.org", CRITICAL), clean(ip, "bl.spamcop.net")},
+			state:   OK,
+		},
+		{
+			name:    "unreachable override to ok is not counted",
+			config:  "lists:\n  bl.spamcop.net:\n    unreachableState: ok\n",
+			results: []*dnsInfo{clean(ip, "zen.spamhaus.org"), unreachable(ip, "bl.spamcop.net")},
+			state:   OK,
+		},
+		{
+			name:    "unreachable override to critical",
+			config:  "lists:\n  bl.spamcop.net:\n    unreachableState: critical\n",
+			results: []*dnsInfo{clean(ip, "zen.spamhaus.org"), unreachable(ip, "bl.spamcop.net")},
+			state:   CRITICAL,
+		},
+		{
+			name:     "suppresscrit",
+			suppress: true,
+			results:  []*dnsInfo{listing(ip, "zen.spamhaus.org", CRITICAL), clean(ip, "bl.spamcop.net")},
+			state:    WARNING,
+		},
+		{
+			name:     "suppresscrit with unreachable override",
+			config:   "lists:\n  bl.spamcop.net:\n    unreachableState: critical\n",
+			suppress: true,
+			results:  []*dnsInfo{clean(ip, "zen.spamhaus.org"), unreachable(ip, "bl.spamcop.net")},
+			state:    WARNING,
+		},
+		{
+			name:  "thresholds apply to each ip",
+			specs: []string{"0", "1", "", "", "0", ""},
+			ips:   []net.IP{ip, otherIP},
+			results: []*dnsInfo{
+				listing(ip, "zen.spamhaus.org", CRITICAL), clean(ip, "bl.spamcop.net"),
+				listing(otherIP, "zen.spamhaus.org", CRITICAL), clean(otherIP, "bl.spamcop.net"),
+			},
+			state: WARNING,
+		},
+		{
+			name: "worst ip wins",
+			ips:  []net.IP{ip, otherIP},
+			results: []*dnsInfo{
+				clean(ip, "zen.spamhaus.org"), clean(ip, "bl.spamcop.net"),
+				listing(otherIP, "zen.spamhaus.org", CRITICAL), clean(otherIP, "bl.spamcop.net"),
+			},
+			state: CRITICAL,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.config != "" {
+				readTestConfig(t, test.config)
+			}
+			saved := SuppressCrit
+			SuppressCrit = test.suppress
+			defer func() { SuppressCrit = saved }()
+
+			var thresholds *checkThresholds
+			if test.specs == nil {
+				var err error
+				if thresholds, err = parseCheckThresholds(); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				thresholds = testThresholds(t, test.specs...)
+			}
+			ips := test.ips
+			if ips == nil {
+				ips = []net.IP{ip}
+			}
+
+			if report := newCheckReport(ips, test.results, thresholds); report.state != test.state {
+				t.Errorf("got state %d, want %d (%s)", report.state, test.state, report.summary())
+			}
+		})
+	}
+}
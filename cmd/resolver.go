@@ -0,0 +1,535 @@
+// Copyright © 2018-2022 viafintech GmbH
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const resolvConfPath = "/etc/resolv.conf"
+
+// resolver sends a single question to an upstream and returns its answer.
+// Implementations have to be safe for concurrent use.
+type resolver interface {
+	exchange(ctx context.Context, name string, qtype uint16) (*dnsMessage, error)
+	String() string
+}
+
+// newResolver creates the resolver pool for the blacklist queries. The
+// upstreams are taken from the upstreams option, if it is empty they are
+// built from the resolver option and its nameservers.
+func newResolver() (resolver, error) {
+	specs := Upstreams
+	if len(specs) == 0 {
+		var err error
+		specs, err = defaultUpstreams()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	upstreams := make([]resolver, 0, len(specs))
+	for _, spec := range specs {
+		upstream, err := newUpstream(spec)
+		if err != nil {
+			return nil, err
+		}
+		upstreams = append(upstreams, upstream)
+	}
+
+	return newResolverPool(upstreams, UpstreamTimeout, UpstreamMaxFailures, UpstreamCooldown), nil
+}
+
+// defaultUpstreams returns the upstream specifications of the resolver option.
+func defaultUpstreams() ([]string, error) {
+	switch Resolver {
+	case "doh":
+		if DoHFormat == dohFormatJSON {
+			return []string{DoHURL}, nil
+		}
+		return []string{DoHURL + "#" + DoHFormat}, nil
+	case "dns", "dot":
+		nameservers := Nameservers
+		if len(nameservers) == 0 {
+			if Resolver == "dot" {
+				return nil, errors.New("the dot resolver needs at least one nameserver")
+			}
+			var err error
+			nameservers, err = readResolvConf(resolvConfPath)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		scheme := "dns://"
+		if Resolver == "dot" {
+			scheme = "tls://"
+		}
+		specs := make([]string, 0, len(nameservers))
+		for _, nameserver := range nameservers {
+			if ip := net.ParseIP(nameserver); ip != nil && ip.To4() == nil {
+				nameserver = "[" + nameserver + "]"
+			}
+			specs = append(specs, scheme+nameserver)
+		}
+		return specs, nil
+	}
+	return nil, fmt.Errorf("unknown resolver %q, expected doh, dns or dot", Resolver)
+}
+
+// newUpstream creates a single upstream resolver from its specification:
+//
+//	dns://host[:port]                           udp with tcp fallback
+//	tls://host[:port][?servername=name&cafile=path] dns over tls
+//	https://host/path[#json|get|post]           dns over https
+func newUpstream(spec string) (resolver, error) {
+	upstreamURL, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream %q: %s", spec, err.Error())
+	}
+	if upstreamURL.Host == "" {
+		return nil, fmt.Errorf("invalid upstream %q, the host is missing", spec)
+	}
+
+	switch upstreamURL.Scheme {
+	case "dns":
+		return &dnsResolver{server: withDefaultPort(upstreamURL.Host, "53")}, nil
+	case "tls":
+		serverName := TLSServerName
+		if name := upstreamURL.Query().Get("servername"); name != "" {
+			serverName = name
+		}
+		caFile := TLSCAFile
+		if file := upstreamURL.Query().Get("cafile"); file != "" {
+			caFile = file
+		}
+		return newDoTResolver(withDefaultPort(upstreamURL.Host, "853"), serverName, caFile)
+	case "https", "http":
+		format := upstreamURL.Fragment
+		if format == "" {
+			format = dohFormatJSON
+		}
+		upstreamURL.Fragment = ""
+		return newDoHResolver(upstreamURL.String(), format)
+	}
+	return nil, fmt.Errorf("invalid upstream %q, expected a dns://, tls:// or https:// url", spec)
+}
+
+// readResolvConf returns the nameservers listed in a resolv.conf file.
+func readResolvConf(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var servers []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, fields[1])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("no nameserver found in %s", path)
+	}
+	return servers, nil
+}
+
+func withDefaultPort(server string, port string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(strings.Trim(server, "[]"), port)
+}
+
+// dnsResolver queries a nameserver over udp and repeats the query over tcp if
+// the udp answer was truncated.
+type dnsResolver struct {
+	server string
+}
+
+func (r *dnsResolver) String() string {
+	return "dns://" + r.server
+}
+
+func (r *dnsResolver) exchange(ctx context.Context, name string, qtype uint16) (*dnsMessage, error) {
+	id := uint16(rand.Uint32())
+	query, err := packDNSQuery(id, name, qtype)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := exchangeUDP(ctx, r.server, query)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Truncated {
+		msg, err = exchangeTCP(ctx, r.server, query)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if msg.ID != id {
+		return nil, fmt.Errorf("dns answer from %s has mismatching id", r.server)
+	}
+	return msg, nil
+}
+
+func exchangeUDP(ctx context.Context, server string, query []byte) (*dnsMessage, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	setConnDeadline(ctx, conn)
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		msg, err := parseDNSMessage(buf[:n])
+		if err != nil || msg.ID != binary.BigEndian.Uint16(query) {
+			// ignore stray or garbled datagrams and wait for the real answer
+			continue
+		}
+		return msg, nil
+	}
+}
+
+func exchangeTCP(ctx context.Context, server string, query []byte) (*dnsMessage, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	setConnDeadline(ctx, conn)
+
+	return exchangeStream(conn, query)
+}
+
+// exchangeStream sends a query over a stream connection using the two byte
+// length prefix of RFC 1035 section 4.2.2 and reads the answer.
+func exchangeStream(conn io.ReadWriter, query []byte) (*dnsMessage, error) {
+	framed := make([]byte, 2, 2+len(query))
+	binary.BigEndian.PutUint16(framed, uint16(len(query)))
+	framed = append(framed, query...)
+	if _, err := conn.Write(framed); err != nil {
+		return nil, err
+	}
+
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	answer := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, answer); err != nil {
+		return nil, err
+	}
+	return parseDNSMessage(answer)
+}
+
+func setConnDeadline(ctx context.Context, conn net.Conn) {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+}
+
+// dotMaxConns is the number of connections opened to a dns over tls server.
+// Queries beyond that wait for a connection to become free.
+const dotMaxConns = 4
+
+// dotResolver sends the queries over tls (RFC 7858). Connections are kept
+// open after a query and shared by all concurrent queries of a check, so the
+// tls handshake is only paid once per connection instead of per query.
+type dotResolver struct {
+	server    string
+	tlsConfig *tls.Config
+
+	// pool holds a slot per allowed connection. A slot is either an idle
+	// connection or nil if the connection still has to be dialed.
+	pool chan *tls.Conn
+}
+
+func newDoTResolver(server string, serverName string, caFile string) (*dotResolver, error) {
+	tlsConfig := &tls.Config{ServerName: serverName}
+
+	if caFile != "" {
+		caBundle, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading the ca bundle failed: %s", err.Error())
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caBundle) {
+			return nil, fmt.Errorf("no certificate found in ca bundle %s", caFile)
+		}
+	}
+
+	pool := make(chan *tls.Conn, dotMaxConns)
+	for i := 0; i < dotMaxConns; i++ {
+		pool <- nil
+	}
+
+	return &dotResolver{server: server, tlsConfig: tlsConfig, pool: pool}, nil
+}
+
+func (r *dotResolver) String() string {
+	return "tls://" + r.server
+}
+
+func (r *dotResolver) exchange(ctx context.Context, name string, qtype uint16) (*dnsMessage, error) {
+	return r.exchangeWithin(ctx, name, qtype, 0)
+}
+
+// exchangeWithin waits for a free connection and gives the server timeout to
+// answer from then on. The wait for the connection is local, it must not make
+// a busy but healthy server look slow.
+func (r *dotResolver) exchangeWithin(ctx context.Context, name string, qtype uint16, timeout time.Duration) (*dnsMessage, error) {
+	id := uint16(rand.Uint32())
+	query, err := packDNSQuery(id, name, qtype)
+	if err != nil {
+		return nil, err
+	}
+
+	queryCtx := ctx
+	for {
+		var conn *tls.Conn
+		select {
+		case conn = <-r.pool:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if timeout > 0 && queryCtx == ctx {
+			var cancel context.CancelFunc
+			queryCtx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		reused := conn != nil
+		if !reused {
+			dialer := &tls.Dialer{Config: r.tlsConfig}
+			netConn, err := dialer.DialContext(queryCtx, "tcp", r.server)
+			if err != nil {
+				r.pool <- nil
+				return nil, err
+			}
+			conn = netConn.(*tls.Conn)
+		}
+		setConnDeadline(queryCtx, conn)
+
+		msg, err := exchangeStream(conn, query)
+		if err == nil && msg.ID != id {
+			err = fmt.Errorf("dns answer from %s has mismatching id", r.server)
+		}
+		if err != nil {
+			conn.Close()
+			r.pool <- nil
+			// the server may have closed an idle connection in the meantime,
+			// so only a failure on a fresh connection is final
+			if reused && queryCtx.Err() == nil {
+				continue
+			}
+			return nil, err
+		}
+
+		conn.SetDeadline(time.Time{})
+		r.pool <- conn
+		return msg, nil
+	}
+}
+
+const (
+	dohFormatJSON = "json"
+	dohFormatGET  = "get"
+	dohFormatPOST = "post"
+
+	dnsJSONContentType    = "application/dns-json"
+	dnsMessageContentType = "application/dns-message"
+)
+
+// dohResolver sends the queries to a dns over https server. Depending on the
+// format it either uses the json api known from cloudflare and google or the
+// RFC 8484 wire format in a GET or POST request.
+type dohResolver struct {
+	client *http.Client
+	url    *url.URL
+	format string
+}
+
+type dohJSONResponse struct {
+	Status int
+	TC     bool
+	Answer []struct {
+		Name string `json:"name"`
+		Type uint16 `json:"type"`
+		TTL  uint32 `json:"TTL"`
+		Data string `json:"data"`
+	}
+}
+
+func newDoHResolver(rawURL string, format string) (*dohResolver, error) {
+	endpoint, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dns over https url: %s", err.Error())
+	}
+	if endpoint.Scheme != "https" && endpoint.Scheme != "http" {
+		return nil, fmt.Errorf("invalid dns over https url %q, expected a http(s) url", rawURL)
+	}
+
+	switch format {
+	case dohFormatJSON, dohFormatGET, dohFormatPOST:
+	default:
+		return nil, fmt.Errorf("unknown dns over https format %q, expected json, get or post", format)
+	}
+
+	// the queries are limited by their context, the client timeout only
+	// guards against requests without a deadline
+	client := &http.Client{Timeout: time.Duration(Timeout) * time.Second}
+	return &dohResolver{client: client, url: endpoint, format: format}, nil
+}
+
+func (r *dohResolver) String() string {
+	return r.url.String()
+}
+
+func (r *dohResolver) exchange(ctx context.Context, name string, qtype uint16) (*dnsMessage, error) {
+	dnsReq, err := r.newRequest(ctx, name, qtype)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create new dns request: %s", err.Error())
+	}
+
+	dnsResp, err := r.client.Do(dnsReq)
+	if err != nil {
+		return nil, fmt.Errorf("the dns over https request failed with: %s", err.Error())
+	}
+	defer dnsResp.Body.Close()
+
+	if dnsResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("the dns over https request returned %s", dnsResp.Status)
+	}
+
+	respBody, err := io.ReadAll(dnsResp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading the dns body failed: %s", err.Error())
+	}
+
+	if r.format == dohFormatJSON {
+		return parseDoHJSON(respBody)
+	}
+	return parseDNSMessage(respBody)
+}
+
+func (r *dohResolver) newRequest(ctx context.Context, name string, qtype uint16) (*http.Request, error) {
+	reqURL := *r.url
+	query := reqURL.Query()
+
+	if r.format == dohFormatJSON {
+		query.Set("name", name)
+		query.Set("type", dnsTypeString(qtype))
+		reqURL.RawQuery = query.Encode()
+
+		req, err := http.NewRequestWithContext(ctx, "GET", reqURL.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("Accept", dnsJSONContentType)
+		return req, nil
+	}
+
+	// RFC 8484 section 4.1 recommends the id 0 to make the requests cacheable
+	wireQuery, err := packDNSQuery(0, name, qtype)
+	if err != nil {
+		return nil, err
+	}
+
+	var req *http.Request
+	if r.format == dohFormatPOST {
+		req, err = http.NewRequestWithContext(ctx, "POST", reqURL.String(), bytes.NewReader(wireQuery))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("Content-Type", dnsMessageContentType)
+	} else {
+		query.Set("dns", base64.RawURLEncoding.EncodeToString(wireQuery))
+		reqURL.RawQuery = query.Encode()
+		req, err = http.NewRequestWithContext(ctx, "GET", reqURL.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	req.Header.Add("Accept", dnsMessageContentType)
+	return req, nil
+}
+
+func parseDoHJSON(body []byte) (*dnsMessage, error) {
+	var dnsData dohJSONResponse
+	if err := json.Unmarshal(body, &dnsData); err != nil {
+		return nil, fmt.Errorf("unmarshalling the dns response failed: %s", err.Error())
+	}
+
+	msg := &dnsMessage{Rcode: dnsData.Status, Truncated: dnsData.TC}
+	for _, answer := range dnsData.Answer {
+		data := answer.Data
+		if answer.Type == dnsTypeTXT {
+			data = strings.ReplaceAll(strings.Trim(data, `"`), `" "`, "")
+		}
+		msg.Answers = append(msg.Answers, dnsRecord{
+			Name: answer.Name,
+			Type: answer.Type,
+			TTL:  answer.TTL,
+			Data: data,
+		})
+	}
+	return msg, nil
+}
+
+func init() {
+	rand.Seed(time.Now().UnixNano())
+}
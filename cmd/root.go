@@ -21,16 +21,47 @@
 package cmd
 
 import (
-	"log"
-	"os"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
 var cfgFile string
 var Timeout int
 var SuppressCrit bool
+var Resolver string
+var Nameservers []string
+var DoHURL string
+var DoHFormat string
+var TLSServerName string
+var TLSCAFile string
+var Upstreams []string
+var UpstreamTimeout time.Duration
+var UpstreamMaxFailures int
+var UpstreamCooldown time.Duration
+var MaxAddresses int
+var QueryTimeout time.Duration
+var Retries int
+var RetryBackoff time.Duration
+var WarningThreshold string
+var CriticalThreshold string
+var ScoreWarningThreshold string
+var ScoreCriticalThreshold string
+var UnreachableWarningThreshold string
+var UnreachableCriticalThreshold string
+var PerfdataLatency bool
+var Verbosity int
+var AckFile string
+var Output string
+var Textfile string
+var CheckmkPerList bool
+var IPs []string
+var ListenAddress string
+var CheckInterval time.Duration
+var StateFile string
+var NewListingAge time.Duration
 var BlacklistServers = []string{
 	"all.s5h.net",
 	"b.barracudacentral.org",
@@ -107,8 +138,7 @@ Current Version: 2.0.7`,
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
 	if err := RootCmd.Execute(); err != nil {
-		log.Println(err)
-		os.Exit(UNKNOWN)
+		exitWithStatus(UNKNOWN, err.Error())
 	}
 }
 
@@ -118,22 +148,168 @@ func init() {
 	RootCmd.PersistentFlags().IntVarP(&Timeout, "timeout", "t", 30, "Pick a timeout in seconds")
 	RootCmd.PersistentFlags().BoolVarP(&SuppressCrit, "suppresscrit", "s", false,
 		"Suppress critical message from the system and send warning instead.")
+	RootCmd.PersistentFlags().StringVar(&Resolver, "resolver", "doh",
+		"Resolver used for the blacklist queries: doh (dns over https), dns (udp with tcp fallback) or dot (dns over tls)")
+	RootCmd.PersistentFlags().StringSliceVar(&Nameservers, "nameserver", nil,
+		"Nameserver for the dns resolver, may be repeated (default are the nameservers of /etc/resolv.conf)")
+	RootCmd.PersistentFlags().StringVar(&DoHURL, "doh-url", "https://cloudflare-dns.com/dns-query",
+		"Url of the dns over https server used by the doh resolver")
+	RootCmd.PersistentFlags().StringVar(&DoHFormat, "doh-format", "json",
+		"Format of the dns over https requests: json, get or post (RFC 8484 wire format)")
+	RootCmd.PersistentFlags().StringVar(&TLSServerName, "tls-server-name", "",
+		"Name used to verify the certificate of the dot nameservers (default is the nameserver host)")
+	RootCmd.PersistentFlags().StringVar(&TLSCAFile, "tls-ca-file", "",
+		"PEM bundle with the certificate authorities for the dot nameservers (default are the system CAs)")
+	RootCmd.PersistentFlags().StringSliceVar(&Upstreams, "upstream", nil,
+		"Upstream resolver as dns://host[:port], tls://host[:port] or https://host/path[#json|get|post], may be repeated (replaces --resolver)")
+	RootCmd.PersistentFlags().DurationVar(&UpstreamTimeout, "upstream-timeout", 3*time.Second,
+		"Time an upstream resolver gets to answer before the query is retried on the next one")
+	RootCmd.PersistentFlags().IntVar(&UpstreamMaxFailures, "upstream-max-failures", 3,
+		"Number of failed queries in a row after which an upstream resolver is sidelined")
+	RootCmd.PersistentFlags().DurationVar(&UpstreamCooldown, "upstream-cooldown", time.Minute,
+		"Time a failing upstream resolver is sidelined")
+	RootCmd.PersistentFlags().DurationVar(&QueryTimeout, "query-timeout", 10*time.Second,
+		"Time a single query of a list may take before it fails, 0 waits until the timeout of the check")
+	RootCmd.PersistentFlags().IntVar(&Retries, "retries", 1,
+		"Number of times a failed query of a list is repeated before the list counts as unreachable")
+	RootCmd.PersistentFlags().DurationVar(&RetryBackoff, "retry-backoff", 500*time.Millisecond,
+		"Time to wait before repeating a failed query, doubled for every further retry")
+	RootCmd.PersistentFlags().IntVar(&MaxAddresses, "max-addresses", 256,
+		"Maximum number of ip addresses checked at once, including the addresses of networks")
 }
 
 func initConfig() {
 	if cfgFile != "" {
 		viper.SetConfigFile(cfgFile)
+	} else {
+		viper.SetConfigName(".nagios-dnsblklist") // name of config file (without extension)
+		viper.AddConfigPath("$HOME")              // adding home directory as first search path
 	}
 
-	viper.SetConfigName(".nagios-dnsblklist") // name of config file (without extension)
-	viper.SetConfigType("yaml")               // default config file type is yaml
-	viper.AddConfigPath("$HOME")              // adding home directory as first search path
-	viper.AutomaticEnv()                      // read in environment variables that match
+	viper.SetConfigType("yaml") // default config file type is yaml
+	viper.AutomaticEnv()        // read in environment variables that match
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
-		BlacklistServers = viper.GetStringSlice("blacklistServers")
-		Timeout = viper.GetInt("timeout")
-		SuppressCrit = viper.GetBool("suppresscrit")
+		if viper.IsSet("blacklistServers") {
+			BlacklistServers = uniqueBlacklists(viper.GetStringSlice("blacklistServers"))
+		}
+		if configured("timeout", "timeout", RootCmd.PersistentFlags()) {
+			Timeout = viper.GetInt("timeout")
+		}
+		if configured("suppresscrit", "suppresscrit", RootCmd.PersistentFlags()) {
+			SuppressCrit = viper.GetBool("suppresscrit")
+		}
+		if configured("resolver", "resolver", RootCmd.PersistentFlags()) {
+			Resolver = viper.GetString("resolver")
+		}
+		if configured("nameservers", "nameserver", RootCmd.PersistentFlags()) {
+			Nameservers = viper.GetStringSlice("nameservers")
+		}
+		if configured("dohUrl", "doh-url", RootCmd.PersistentFlags()) {
+			DoHURL = viper.GetString("dohUrl")
+		}
+		if configured("dohFormat", "doh-format", RootCmd.PersistentFlags()) {
+			DoHFormat = viper.GetString("dohFormat")
+		}
+		if configured("tlsServerName", "tls-server-name", RootCmd.PersistentFlags()) {
+			TLSServerName = viper.GetString("tlsServerName")
+		}
+		if configured("tlsCaFile", "tls-ca-file", RootCmd.PersistentFlags()) {
+			TLSCAFile = viper.GetString("tlsCaFile")
+		}
+		if configured("upstreams", "upstream", RootCmd.PersistentFlags()) {
+			Upstreams = viper.GetStringSlice("upstreams")
+		}
+		if configured("upstreamTimeout", "upstream-timeout", RootCmd.PersistentFlags()) {
+			UpstreamTimeout = viper.GetDuration("upstreamTimeout")
+		}
+		if configured("upstreamMaxFailures", "upstream-max-failures", RootCmd.PersistentFlags()) {
+			UpstreamMaxFailures = viper.GetInt("upstreamMaxFailures")
+		}
+		if configured("upstreamCooldown", "upstream-cooldown", RootCmd.PersistentFlags()) {
+			UpstreamCooldown = viper.GetDuration("upstreamCooldown")
+		}
+		if configured("queryTimeout", "query-timeout", RootCmd.PersistentFlags()) {
+			QueryTimeout = viper.GetDuration("queryTimeout")
+		}
+		if configured("retries", "retries", RootCmd.PersistentFlags()) {
+			Retries = viper.GetInt("retries")
+		}
+		if configured("retryBackoff", "retry-backoff", RootCmd.PersistentFlags()) {
+			RetryBackoff = viper.GetDuration("retryBackoff")
+		}
+		if configured("maxAddresses", "max-addresses", RootCmd.PersistentFlags()) {
+			MaxAddresses = viper.GetInt("maxAddresses")
+		}
+		if configured("warning", "warning", checkCmd.Flags()) {
+			WarningThreshold = viper.GetString("warning")
+		}
+		if configured("critical", "critical", checkCmd.Flags()) {
+			CriticalThreshold = viper.GetString("critical")
+		}
+		if configured("scoreWarning", "score-warning", checkCmd.Flags()) {
+			ScoreWarningThreshold = viper.GetString("scoreWarning")
+		}
+		if configured("scoreCritical", "score-critical", checkCmd.Flags()) {
+			ScoreCriticalThreshold = viper.GetString("scoreCritical")
+		}
+		if configured("unreachableWarning", "unreachable-warning", checkCmd.Flags()) {
+			UnreachableWarningThreshold = viper.GetString("unreachableWarning")
+		}
+		if configured("unreachableCritical", "unreachable-critical", checkCmd.Flags()) {
+			UnreachableCriticalThreshold = viper.GetString("unreachableCritical")
+		}
+		if configured("verbosity", "verbose", checkCmd.Flags()) {
+			Verbosity = viper.GetInt("verbosity")
+		}
+		if configured("output", "output", checkCmd.Flags()) {
+			Output = viper.GetString("output")
+		}
+		if configured("textfile", "textfile", checkCmd.Flags()) {
+			Textfile = viper.GetString("textfile")
+		}
+		if configured("checkmkPerList", "checkmk-per-list", checkCmd.Flags()) {
+			CheckmkPerList = viper.GetBool("checkmkPerList")
+		}
+		if viper.IsSet("ips") {
+			IPs = viper.GetStringSlice("ips")
+		}
+		if configured("listen", "listen", serveCmd.Flags()) {
+			ListenAddress = viper.GetString("listen")
+		}
+		if configured("interval", "interval", serveCmd.Flags()) {
+			CheckInterval = viper.GetDuration("interval")
+		}
+		if configured("ackFile", "ack-file", checkCmd.Flags(), zabbixGetCmd.Flags()) {
+			AckFile = viper.GetString("ackFile")
+		}
+		if configured("stateFile", "state-file", checkCmd.Flags()) {
+			StateFile = viper.GetString("stateFile")
+		}
+		if configured("newListingAge", "new-listing-age", checkCmd.Flags()) {
+			NewListingAge = viper.GetDuration("newListingAge")
+		}
+		if configured("perfdataLatency", "perfdata-latency", checkCmd.Flags()) {
+			PerfdataLatency = viper.GetBool("perfdataLatency")
+		}
+		if err := loadBlacklistConfigs(); err != nil {
+			exitWithStatus(UNKNOWN, err.Error())
+		}
 	}
 }
+
+// configured reports whether key is set in the config file and the flag of
+// the same setting was not given to any of the commands defining it, flags
+// take precedence over the config file.
+func configured(key string, flag string, flagSets ...*pflag.FlagSet) bool {
+	if !viper.IsSet(key) {
+		return false
+	}
+	for _, flags := range flagSets {
+		if flags.Changed(flag) {
+			return false
+		}
+	}
+	return true
+}
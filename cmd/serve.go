@@ -0,0 +1,192 @@
+// Copyright © 2018-2022 viafintech GmbH
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve [ip-addresses or networks]",
+	Short: "Checks the ips periodically and serves the results as prometheus metrics.",
+	Long: `Checks the supplied ip-addresses and networks, or the ones of the ips config
+key, every interval against the blacklists and serves the results on /metrics
+in the prometheus text format:
+* dnsbl_listed{ip,list,code}: 1 if the ip is listed with the return code
+* dnsbl_query_duration_seconds{ip,list}: latency of the last query
+* dnsbl_query_errors_total{ip,list}: number of failed queries and error answers
+* dnsbl_last_check_timestamp: unix time of the last completed check`,
+	Run: func(cmd *cobra.Command, args []string) {
+		// without a pause the lists would be queried back to back
+		if CheckInterval <= 0 {
+			log.Fatalf("The interval has to be positive, got %s", CheckInterval)
+		}
+		if len(args) == 0 {
+			args = IPs
+		}
+		ips, err := parseIPArguments(args, MaxAddresses)
+		if err != nil {
+			log.Fatalln("Please specify correct ip addresses or networks:", err)
+		}
+
+		res, err := newResolver()
+		if err != nil {
+			log.Fatalln("Failed to set up the resolver:", err)
+		}
+
+		exporter := newExporter()
+		go func() {
+			for {
+				exporter.update(runChecks(res, ips), time.Now())
+				time.Sleep(CheckInterval)
+			}
+		}()
+
+		http.Handle("/metrics", exporter)
+		log.Println("Serving the metrics on", ListenAddress+"/metrics")
+		log.Fatalln(http.ListenAndServe(ListenAddress, nil))
+	},
+}
+
+// exporter keeps the results of the last check and the query error counters
+// and renders them as prometheus metrics.
+type exporter struct {
+	mu          sync.Mutex
+	results     []*dnsInfo
+	errorsTotal map[string]float64
+	lastCheck   time.Time
+
+	// singleRun exporters only see a single check, they report its query
+	// errors as gauge since a counter would be reset by every run.
+	singleRun bool
+}
+
+func newExporter() *exporter {
+	return &exporter{errorsTotal: map[string]float64{}}
+}
+
+func (e *exporter) update(results []*dnsInfo, now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, result := range results {
+		key := metricLabels("ip", result.ip.String(), "list", result.blacklist)
+		if _, ok := e.errorsTotal[key]; !ok {
+			e.errorsTotal[key] = 0
+		}
+		if queryFailed(result) {
+			e.errorsTotal[key]++
+		}
+	}
+	e.results = results
+	e.lastCheck = now
+}
+
+func (e *exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.writeMetrics(w)
+}
+
+func (e *exporter) writeMetrics(w io.Writer) {
+	fmt.Fprintln(w, "# HELP dnsbl_listed Whether the ip is listed on the list with the return code.")
+	fmt.Fprintln(w, "# TYPE dnsbl_listed gauge")
+	for _, result := range e.results {
+		// a refused query or an unexpected answer says nothing about the
+		// listing, it must not look like a clean ip
+		if queryFailed(result) {
+			continue
+		}
+		if !result.listed {
+			fmt.Fprintf(w, "dnsbl_listed{%s} 0\n", metricLabels(
+				"ip", result.ip.String(), "list", result.blacklist, "code", ""))
+			continue
+		}
+		for _, code := range result.codes {
+			fmt.Fprintf(w, "dnsbl_listed{%s} 1\n", metricLabels(
+				"ip", result.ip.String(), "list", result.blacklist, "code", code))
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP dnsbl_query_duration_seconds Duration of the last query of the list.")
+	fmt.Fprintln(w, "# TYPE dnsbl_query_duration_seconds gauge")
+	for _, result := range e.results {
+		if result.latency > 0 {
+			fmt.Fprintf(w, "dnsbl_query_duration_seconds{%s} %g\n", metricLabels(
+				"ip", result.ip.String(), "list", result.blacklist), result.latency.Seconds())
+		}
+	}
+
+	name, help, kind := "dnsbl_query_errors_total", "Number of queries of the list which failed, timed out or got an error answer.", "counter"
+	if e.singleRun {
+		name, help, kind = "dnsbl_query_errors", "Whether the query of the list failed, timed out or got an error answer.", "gauge"
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+	keys := make([]string, 0, len(e.errorsTotal))
+	for key := range e.errorsTotal {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s{%s} %g\n", name, key, e.errorsTotal[key])
+	}
+
+	if !e.lastCheck.IsZero() {
+		fmt.Fprintln(w, "# HELP dnsbl_last_check_timestamp Unix time of the last completed check.")
+		fmt.Fprintln(w, "# TYPE dnsbl_last_check_timestamp gauge")
+		fmt.Fprintf(w, "dnsbl_last_check_timestamp %d\n", e.lastCheck.Unix())
+	}
+}
+
+// queryFailed reports whether a list gave no answer about the listing: it was
+// unreachable, refused the query with an error code or gave an unexpected
+// answer.
+func queryFailed(result *dnsInfo) bool {
+	return result.unreachable || (!result.listed && result.returnCode != OK)
+}
+
+// metricLabels formats label names and values as label set of a metric.
+func metricLabels(namesAndValues ...string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	labels := make([]string, 0, len(namesAndValues)/2)
+	for i := 0; i+1 < len(namesAndValues); i += 2 {
+		labels = append(labels, fmt.Sprintf(`%s="%s"`, namesAndValues[i], replacer.Replace(namesAndValues[i+1])))
+	}
+	return strings.Join(labels, ",")
+}
+
+func init() {
+	RootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().StringVar(&ListenAddress, "listen", ":9815", "Address the metrics are served on")
+	serveCmd.Flags().DurationVar(&CheckInterval, "interval", 5*time.Minute, "Time between two checks of the ips")
+}
@@ -0,0 +1,151 @@
+// Copyright © 2018-2022 viafintech GmbH
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// staleListingAge is the time after which the record of a listing which was
+// not seen anymore is removed, e.g. because the ip or the list is not
+// checked anymore or the list stayed unreachable.
+const staleListingAge = 30 * 24 * time.Hour
+
+// listingRecord is the entry of the state file for a listing of an ip.
+type listingRecord struct {
+	IP        string    `json:"ip"`
+	List      string    `json:"list"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+// checkState is the content of the state file. It keeps the listings across
+// the runs of the check, keyed by ip and list, to tell new listings from
+// long-standing ones.
+type checkState struct {
+	Listings map[string]*listingRecord `json:"listings"`
+}
+
+// loadCheckState reads the state file, a missing file results in an empty
+// state.
+func loadCheckState(path string) (*checkState, error) {
+	state := &checkState{Listings: map[string]*listingRecord{}}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the state file: %s", err.Error())
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %s", path, err.Error())
+	}
+	if state.Listings == nil {
+		state.Listings = map[string]*listingRecord{}
+	}
+	return state, nil
+}
+
+// save writes the state file atomically, so a check running at the same time
+// never reads a partial file.
+func (s *checkState) save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'), 0644)
+}
+
+// update records the listings of the results and marks the ones first seen
+// less than newListingAge ago as new. Only new listings may be critical, the
+// others are limited to a warning. Listings are only removed once a list
+// answers that the ip is not listed, the ones of unreachable lists and of
+// lists with unknown answers are kept until they are stale.
+func (s *checkState) update(results []*dnsInfo, now time.Time, newListingAge time.Duration) {
+	for _, result := range results {
+		key := result.ip.String() + " " + result.blacklist
+		if result.unreachable || (!result.listed && result.returnCode != OK) {
+			continue
+		}
+		if !result.listed || (result.returnCode == OK && result.acknowledged == nil) {
+			delete(s.Listings, key)
+			continue
+		}
+
+		record, ok := s.Listings[key]
+		if !ok {
+			record = &listingRecord{IP: result.ip.String(), List: result.blacklist, FirstSeen: now}
+			s.Listings[key] = record
+		}
+		record.LastSeen = now
+
+		result.firstSeen = record.FirstSeen
+		result.newListing = now.Sub(record.FirstSeen) < newListingAge
+		if !result.newListing && result.returnCode == CRITICAL {
+			result.returnCode = WARNING
+		}
+	}
+
+	for key, record := range s.Listings {
+		if now.Sub(record.LastSeen) > staleListingAge {
+			delete(s.Listings, key)
+		}
+	}
+}
+
+// formatSince returns the time a listing was first seen, only the time of
+// the day if it was today.
+func formatSince(firstSeen time.Time, now time.Time) string {
+	firstSeen = firstSeen.Local()
+	if firstSeen.Format("2006-01-02") == now.Local().Format("2006-01-02") {
+		return firstSeen.Format("15:04")
+	}
+	return firstSeen.Format("2006-01-02 15:04")
+}
+
+// writeFileAtomic writes data to a temporary file in the directory of path
+// and renames it to path once it is complete.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,203 @@
+// Copyright © 2018-2022 viafintech GmbH
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckStateUpdate(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	ip := net.ParseIP("192.0.2.10").To4()
+	record := func(list string, firstSeen time.Time) *listingRecord {
+		return &listingRecord{IP: ip.String(), List: list, FirstSeen: firstSeen, LastSeen: firstSeen}
+	}
+
+	tests := []struct {
+		name       string
+		record     *listingRecord
+		result     *dnsInfo
+		kept       bool
+		firstSeen  time.Time
+		newListing bool
+		state      int
+	}{
+		{
+			name:       "first listing",
+			result:     &dnsInfo{listed: true, returnCode: CRITICAL},
+			kept:       true,
+			firstSeen:  now,
+			newListing: true,
+			state:      CRITICAL,
+		},
+		{
+			name:       "recent listing stays critical",
+			record:     record("zen.spamhaus.org", now.Add(-time.Hour)),
+			result:     &dnsInfo{listed: true, returnCode: CRITICAL},
+			kept:       true,
+			firstSeen:  now.Add(-time.Hour),
+			newListing: true,
+			state:      CRITICAL,
+		},
+		{
+			name:      "old listing is limited to a warning",
+			record:    record("zen.spamhaus.org", now.Add(-48*time.Hour)),
+			result:    &dnsInfo{listed: true, returnCode: CRITICAL},
+			kept:      true,
+			firstSeen: now.Add(-48 * time.Hour),
+			state:     WARNING,
+		},
+		{
+			name:      "old warning listing",
+			record:    record("zen.spamhaus.org", now.Add(-48*time.Hour)),
+			result:    &dnsInfo{listed: true, returnCode: WARNING},
+			kept:      true,
+			firstSeen: now.Add(-48 * time.Hour),
+			state:     WARNING,
+		},
+		{
+			name:      "acknowledged listing is kept",
+			record:    record("zen.spamhaus.org", now.Add(-48*time.Hour)),
+			result:    &dnsInfo{listed: true, returnCode: OK, acknowledged: &acknowledgement{}},
+			kept:      true,
+			firstSeen: now.Add(-48 * time.Hour),
+			state:     OK,
+		},
+		{
+			name:   "listing configured as ok is removed",
+			record: record("zen.spamhaus.org", now.Add(-48*time.Hour)),
+			result: &dnsInfo{listed: true, returnCode: OK},
+			state:  OK,
+		},
+		{
+			name:   "clean answer removes the listing",
+			record: record("zen.spamhaus.org", now.Add(-48*time.Hour)),
+			result: &dnsInfo{returnCode: OK},
+			state:  OK,
+		},
+		{
+			name:   "unreachable list keeps the listing",
+			record: record("zen.spamhaus.org", now.Add(-48*time.Hour)),
+			result: &dnsInfo{returnCode: WARNING, unreachable: true},
+			kept:   true,
+			state:  WARNING,
+		},
+		{
+			name:   "unknown answer keeps the listing",
+			record: record("zen.spamhaus.org", now.Add(-48*time.Hour)),
+			result: &dnsInfo{returnCode: UNKNOWN},
+			kept:   true,
+			state:  UNKNOWN,
+		},
+	}
+	for _, test := range tests {
+		state := &checkState{Listings: map[string]*listingRecord{}}
+		key := ip.String() + " zen.spamhaus.org"
+		if test.record != nil {
+			state.Listings[key] = test.record
+		}
+		test.result.ip = ip
+		test.result.blacklist = "zen.spamhaus.org"
+
+		state.update([]*dnsInfo{test.result}, now, 24*time.Hour)
+
+		got, kept := state.Listings[key]
+		if kept != test.kept {
+			t.Errorf("%s: got record kept %v, want %v", test.name, kept, test.kept)
+		}
+		if test.result.returnCode != test.state || test.result.newListing != test.newListing ||
+			!test.result.firstSeen.Equal(test.firstSeen) {
+			t.Errorf("%s: got state %d, new %v, first seen %s, want state %d, new %v, first seen %s", test.name,
+				test.result.returnCode, test.result.newListing, test.result.firstSeen,
+				test.state, test.newListing, test.firstSeen)
+		}
+		if kept && test.result.listed && !got.LastSeen.Equal(now) {
+			t.Errorf("%s: got last seen %s, want %s", test.name, got.LastSeen, now)
+		}
+		if kept && test.record == nil && !got.FirstSeen.Equal(now) {
+			t.Errorf("%s: got first seen %s, want %s", test.name, got.FirstSeen, now)
+		}
+	}
+}
+
+func TestCheckStateUpdateRemovesStaleRecords(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	ip := net.ParseIP("192.0.2.10").To4()
+	state := &checkState{Listings: map[string]*listingRecord{}}
+	for list, lastSeen := range map[string]time.Time{
+		"zen.spamhaus.org": now.Add(-staleListingAge - time.Hour),
+		"bl.spamcop.net":   now.Add(-staleListingAge - time.Hour),
+		"psbl.surriel.com": now.Add(-staleListingAge + time.Hour),
+		"dnsbl.sorbs.net":  now.Add(-staleListingAge - time.Hour),
+	} {
+		state.Listings[ip.String()+" "+list] = &listingRecord{
+			IP: ip.String(), List: list, FirstSeen: lastSeen.Add(-time.Hour), LastSeen: lastSeen,
+		}
+	}
+
+	state.update([]*dnsInfo{
+		{ip: ip, blacklist: "zen.spamhaus.org", listed: true, returnCode: CRITICAL},
+		{ip: ip, blacklist: "dnsbl.sorbs.net", returnCode: WARNING, unreachable: true},
+	}, now, 24*time.Hour)
+
+	for list, kept := range map[string]bool{
+		// listed again
+		"zen.spamhaus.org": true,
+		// not checked anymore
+		"bl.spamcop.net":   false,
+		"psbl.surriel.com": true,
+		// unreachable for too long
+		"dnsbl.sorbs.net": false,
+	} {
+		if _, ok := state.Listings[ip.String()+" "+list]; ok != kept {
+			t.Errorf("%s: got record kept %v, want %v", list, ok, kept)
+		}
+	}
+}
+
+func TestCheckStateSaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	state, err := loadCheckState(path)
+	if err != nil || len(state.Listings) != 0 {
+		t.Fatalf("got %+v and error %v for a missing file, want an empty state", state, err)
+	}
+
+	firstSeen := time.Date(2026, 10, 17, 8, 30, 0, 0, time.UTC)
+	state.Listings["192.0.2.10 zen.spamhaus.org"] = &listingRecord{
+		IP: "192.0.2.10", List: "zen.spamhaus.org", FirstSeen: firstSeen, LastSeen: firstSeen,
+	}
+	if err := state.save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadCheckState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := loaded.Listings["192.0.2.10 zen.spamhaus.org"]
+	if record == nil || !record.FirstSeen.Equal(firstSeen) || record.List != "zen.spamhaus.org" {
+		t.Errorf("got %+v, want the saved record", loaded.Listings)
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright © 2018-2022 viafintech GmbH
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// threshold is a range in the nagios plugin syntax: "10" alerts outside of
+// 0..10, "10:" below 10, "~:10" above 10, "10:20" outside of 10..20 and
+// "@10:20" inside of 10..20. A range with percent signs like "10%" is
+// compared against the percentage of a total instead of the value itself.
+type threshold struct {
+	spec    string
+	start   float64
+	end     float64
+	inside  bool
+	percent bool
+}
+
+// parseThreshold parses a nagios range, an empty spec returns nil which
+// never alerts.
+func parseThreshold(spec string) (*threshold, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+
+	t := &threshold{spec: spec, start: 0, end: math.Inf(1)}
+	rangeSpec := spec
+	if strings.HasPrefix(rangeSpec, "@") {
+		t.inside = true
+		rangeSpec = rangeSpec[1:]
+	}
+	if strings.Contains(rangeSpec, "%") {
+		t.percent = true
+		rangeSpec = strings.Replace(rangeSpec, "%", "", -1)
+	}
+
+	var err error
+	parts := strings.SplitN(rangeSpec, ":", 2)
+	if len(parts) == 1 {
+		t.end, err = strconv.ParseFloat(parts[0], 64)
+	} else {
+		switch parts[0] {
+		case "~":
+			t.start = math.Inf(-1)
+		case "":
+		default:
+			t.start, err = strconv.ParseFloat(parts[0], 64)
+		}
+		if err == nil && parts[1] != "" {
+			t.end, err = strconv.ParseFloat(parts[1], 64)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid threshold %q", spec)
+	}
+	if t.start > t.end {
+		return nil, fmt.Errorf("invalid threshold %q: start is greater than end", spec)
+	}
+	return t, nil
+}
+
+// alert reports whether value out of total is a problem according to the
+// threshold.
+func (t *threshold) alert(value float64, total float64) bool {
+	if t == nil {
+		return false
+	}
+	if t.percent {
+		if total > 0 {
+			value = value * 100 / total
+		} else {
+			value = 0
+		}
+	}
+	outside := value < t.start || value > t.end
+	if t.inside {
+		return !outside
+	}
+	return outside
+}
+
+// String returns the threshold in the nagios range syntax, as used in the
+// performance data. Percentages do not fit the unit of the values, they are
+// left out.
+func (t *threshold) String() string {
+	if t == nil || t.percent {
+		return ""
+	}
+	return t.spec
+}
+
+// level returns the threshold as checkmk level, the lowest count which
+// alerts. Only thresholds alerting above a number can be expressed as level.
+func (t *threshold) level() string {
+	if t == nil || t.percent || t.inside || t.start != 0 || math.IsInf(t.end, 1) {
+		return ""
+	}
+	return strconv.FormatFloat(math.Floor(t.end)+1, 'f', -1, 64)
+}
+
+// checkThresholds are the warning and critical thresholds of a check for the
+// number of lists reporting a listing, the score of the listings and the
+// number of unreachable lists.
+type checkThresholds struct {
+	listedWarning       *threshold
+	listedCritical      *threshold
+	scoreWarning        *threshold
+	scoreCritical       *threshold
+	unreachableWarning  *threshold
+	unreachableCritical *threshold
+}
+
+// parseCheckThresholds parses the configured thresholds. Without any listing
+// or score threshold a single listing is critical.
+func parseCheckThresholds() (*checkThresholds, error) {
+	listedCritical := CriticalThreshold
+	if WarningThreshold == "" && CriticalThreshold == "" &&
+		ScoreWarningThreshold == "" && ScoreCriticalThreshold == "" {
+		listedCritical = "0"
+	}
+
+	t := &checkThresholds{}
+	var err error
+	if t.listedWarning, err = parseThreshold(WarningThreshold); err != nil {
+		return nil, err
+	}
+	if t.listedCritical, err = parseThreshold(listedCritical); err != nil {
+		return nil, err
+	}
+	if t.scoreWarning, err = parseThreshold(ScoreWarningThreshold); err != nil {
+		return nil, err
+	}
+	if t.scoreCritical, err = parseThreshold(ScoreCriticalThreshold); err != nil {
+		return nil, err
+	}
+	if (t.scoreWarning != nil && t.scoreWarning.percent) || (t.scoreCritical != nil && t.scoreCritical.percent) {
+		return nil, errors.New("score thresholds can not be percentages")
+	}
+	if t.unreachableWarning, err = parseThreshold(UnreachableWarningThreshold); err != nil {
+		return nil, err
+	}
+	if t.unreachableCritical, err = parseThreshold(UnreachableCriticalThreshold); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// scoring reports whether the check has score thresholds.
+func (t *checkThresholds) scoring() bool {
+	return t.scoreWarning != nil || t.scoreCritical != nil
+}
+
+// stateFor returns the state of a count out of total according to a warning
+// and a critical threshold.
+func stateFor(value float64, total float64, warning *threshold, critical *threshold) int {
+	switch {
+	case critical.alert(value, total):
+		return CRITICAL
+	case warning.alert(value, total):
+		return WARNING
+	}
+	return OK
+}
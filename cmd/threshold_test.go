@@ -0,0 +1,145 @@
+// Copyright © 2018-2022 viafintech GmbH
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseThreshold(t *testing.T) {
+	for _, spec := range []string{"", " "} {
+		if got, err := parseThreshold(spec); got != nil || err != nil {
+			t.Errorf("%q: got %+v and error %v, want no threshold", spec, got, err)
+		}
+	}
+
+	inf := math.Inf(1)
+	tests := []struct {
+		spec    string
+		start   float64
+		end     float64
+		inside  bool
+		percent bool
+		invalid bool
+	}{
+		{spec: "10", start: 0, end: 10},
+		{spec: "10:", start: 10, end: inf},
+		{spec: "~:10", start: math.Inf(-1), end: 10},
+		{spec: "10:20", start: 10, end: 20},
+		{spec: "@10:20", start: 10, end: 20, inside: true},
+		{spec: "@~:0", start: math.Inf(-1), end: 0, inside: true},
+		{spec: "10%", start: 0, end: 10, percent: true},
+		{spec: "@10%:20%", start: 10, end: 20, inside: true, percent: true},
+		{spec: "1.5:2.5", start: 1.5, end: 2.5},
+		{spec: "abc", invalid: true},
+		{spec: "10:abc", invalid: true},
+		{spec: "~", invalid: true},
+		{spec: "20:10", invalid: true},
+		{spec: "@", invalid: true},
+		{spec: "%", invalid: true},
+	}
+	for _, test := range tests {
+		got, err := parseThreshold(test.spec)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("%q: got %+v, want an error", test.spec, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.spec, err)
+			continue
+		}
+		if got.start != test.start || got.end != test.end || got.inside != test.inside || got.percent != test.percent {
+			t.Errorf("%q: got %v..%v inside %v percent %v, want %v..%v inside %v percent %v",
+				test.spec, got.start, got.end, got.inside, got.percent, test.start, test.end, test.inside, test.percent)
+		}
+	}
+}
+
+func TestThresholdAlert(t *testing.T) {
+	tests := []struct {
+		spec  string
+		value float64
+		total float64
+		alert bool
+	}{
+		{"", 100, 100, false},
+		{"0", 0, 49, false},
+		{"0", 1, 49, true},
+		{"10", 10, 49, false},
+		{"10", 11, 49, true},
+		{"10", -1, 49, true},
+		{"10:", 9, 49, true},
+		{"10:", 10, 49, false},
+		{"~:10", -5, 49, false},
+		{"~:10", 11, 49, true},
+		{"10:20", 9, 49, true},
+		{"10:20", 15, 49, false},
+		{"10:20", 21, 49, true},
+		{"@10:20", 10, 49, true},
+		{"@10:20", 20, 49, true},
+		{"@10:20", 21, 49, false},
+		{"10%", 4, 49, false},
+		{"10%", 5, 49, true},
+		{"10%", 5, 0, false},
+		{"@50%:", 25, 50, true},
+		{"@50%:", 24, 50, false},
+	}
+	for _, test := range tests {
+		threshold, err := parseThreshold(test.spec)
+		if err != nil {
+			t.Fatalf("%q: %v", test.spec, err)
+		}
+		if alert := threshold.alert(test.value, test.total); alert != test.alert {
+			t.Errorf("%q: got alert %v for %v of %v, want %v", test.spec, alert, test.value, test.total, test.alert)
+		}
+	}
+}
+
+func TestThresholdPerfdataAndLevel(t *testing.T) {
+	tests := []struct {
+		spec     string
+		perfdata string
+		level    string
+	}{
+		{"", "", ""},
+		{"0", "0", "1"},
+		{"2.5", "2.5", "3"},
+		{"10:", "10:", ""},
+		{"5:10", "5:10", ""},
+		{"@0:10", "@0:10", ""},
+		{"10%", "", ""},
+	}
+	for _, test := range tests {
+		threshold, err := parseThreshold(test.spec)
+		if err != nil {
+			t.Fatalf("%q: %v", test.spec, err)
+		}
+		if perfdata := threshold.String(); perfdata != test.perfdata {
+			t.Errorf("%q: got perfdata threshold %q, want %q", test.spec, perfdata, test.perfdata)
+		}
+		if level := threshold.level(); level != test.level {
+			t.Errorf("%q: got checkmk level %q, want %q", test.spec, level, test.level)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright © 2018-2022 viafintech GmbH
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var zabbixCmd = &cobra.Command{
+	Use:   "zabbix",
+	Short: "Zabbix low-level discovery and item values.",
+	Long: `Provides the blacklist checks for zabbix: discover returns the ips and lists
+for the low-level discovery, get the value of an item for a single ip and list.`,
+}
+
+var zabbixDiscoverCmd = &cobra.Command{
+	Use:   "discover [ip-addresses or networks]",
+	Short: "Prints the low-level discovery json of all ips and lists.",
+	Long: `Prints the low-level discovery json with the macros {#IP} and {#DNSBL} for
+every supplied ip-address or the ones of the ips config key and every list
+the ip can be checked against.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			args = IPs
+		}
+		ips, err := parseIPArguments(args, MaxAddresses)
+		if err != nil {
+			log.Println("Please specify correct ip addresses or networks:", err)
+			os.Exit(1)
+		}
+
+		data := []map[string]string{}
+		for _, ip := range ips {
+			for _, blacklistServer := range blacklistsFor(ip) {
+				data = append(data, map[string]string{"{#IP}": ip.String(), "{#DNSBL}": blacklistServer})
+			}
+		}
+		discovery, err := json.Marshal(map[string]interface{}{"data": data})
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println(string(discovery))
+	},
+}
+
+var zabbixGetCmd = &cobra.Command{
+	Use:   "get <ip-address> <list>",
+	Short: "Prints whether the ip is listed on the list: 0 not listed, 1 listed, -1 error.",
+	Long: `Checks a single ip-address against a single list and prints the item value:
+* 0: not listed, or the listing is acknowledged or configured as ok
+* 1: listed
+* -1: the list could not be queried or gave an unexpected answer`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		ip, valid := isIPInputValid(args[:1])
+		if valid != OK {
+			log.Printf("%q is no correct ip address", args[0])
+			os.Exit(1)
+		}
+
+		acks, err := loadAcknowledgements()
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		res, err := newResolver()
+		if err != nil {
+			log.Println("Failed to set up the resolver:", err)
+			os.Exit(1)
+		}
+
+		fmt.Println(zabbixValue(res, ip, args[1], acks))
+	},
+}
+
+// zabbixValue queries a list for an ip and returns the item value.
+func zabbixValue(res resolver, ip net.IP, blacklistDomain string, acks []*acknowledgement) int {
+	ret := make(chan *dnsInfo, 1)
+	checkIPAgainstBlacklistDomain(ret, res, blacklistDomain, ip)
+	result := <-ret
+	acknowledgeListings([]*dnsInfo{result}, acks, time.Now())
+
+	switch {
+	case result.listed && result.returnCode != OK:
+		return 1
+	case result.listed, result.returnCode == OK:
+		return 0
+	}
+	return -1
+}
+
+func init() {
+	RootCmd.AddCommand(zabbixCmd)
+	zabbixCmd.AddCommand(zabbixDiscoverCmd)
+	zabbixCmd.AddCommand(zabbixGetCmd)
+	zabbixGetCmd.Flags().StringVar(&AckFile, "ack-file", "",
+		"Yaml file with acknowledgements of listings in addition to the ones of the config file")
+}
@@ -3,7 +3,9 @@ module github.com/Barzahlen/nagios-dnsblklist
 go 1.18
 
 require (
+	github.com/mitchellh/mapstructure v0.0.0-20180511142126-bb74f1db0675
 	github.com/spf13/cobra v0.0.4-0.20180531180338-1e58aa3361fd
+	github.com/spf13/pflag v1.0.2-0.20180601132542-3ebe029320b2
 	github.com/spf13/viper v1.0.3-0.20180507071007-15738813a09d
 )
 
@@ -14,12 +16,10 @@ require (
 	github.com/hashicorp/hcl v0.0.0-20180404174102-ef8a98b0bbce // indirect
 	github.com/inconshreveable/mousetrap v1.0.0 // indirect
 	github.com/magiconair/properties v1.8.0 // indirect
-	github.com/mitchellh/mapstructure v0.0.0-20180511142126-bb74f1db0675 // indirect
 	github.com/pelletier/go-toml v1.2.0 // indirect
 	github.com/spf13/afero v1.1.1 // indirect
 	github.com/spf13/cast v1.2.0 // indirect
 	github.com/spf13/jwalterweatherman v0.0.0-20180109140146-7c0cea34c8ec // indirect
-	github.com/spf13/pflag v1.0.2-0.20180601132542-3ebe029320b2 // indirect
 	github.com/stretchr/testify v1.8.0 // indirect
 	golang.org/x/sys v0.0.0-20171025085633-e82597366816 // indirect
 	golang.org/x/text v0.3.1-0.20180323135613-ab48842968a6 // indirect